@@ -0,0 +1,111 @@
+package chart
+
+import (
+	"fmt"
+	"log"
+	"net/url"
+	"strconv"
+	"sync"
+
+	"github.com/conorbros/las-tools/conf"
+	"github.com/conorbros/las-tools/lastfm"
+	"github.com/conorbros/las-tools/spotify"
+)
+
+type topArtistsResponse struct {
+	Topartists struct {
+		Artist []struct {
+			Name      string `json:"name"`
+			Playcount string `json:"playcount"`
+		} `json:"artist"`
+	} `json:"topartists"`
+}
+
+// getLastFmTopArtists gets the user's top artists for the period. Last.fm only serves placeholder
+// artist images so the images for each artist are found with the Spotify artist search.
+func getLastFmTopArtists(username string, count int, period string) ([]album, error) {
+	// add 50 to the count as a buffer against downloads that fail
+	urlParams := fmt.Sprintf("&user=%s&api_key=%s&format=json&period=%s&limit=%d", url.QueryEscape(username), conf.Config.LastFm.APIKey, period, lastFmLimit(count+50))
+
+	var response topArtistsResponse
+	if err := lastfm.GetCachedJSON(conf.Config.LastFm.UserTopArtistsEndpoint+urlParams, &response); err != nil {
+		return nil, err
+	}
+
+	var artists []album
+	for _, a := range response.Topartists.Artist {
+		playcount, err := strconv.ParseUint(a.Playcount, 10, 64)
+		if err != nil {
+			playcount = 0
+		}
+		artists = append(artists, album{
+			Artist:    a.Name,
+			Playcount: playcount,
+		})
+	}
+
+	return resolveArtistImages(artists)
+}
+
+// resolveArtistImages looks up each artist on Spotify and sets their image urls. Artists
+// without any images are left without image urls.
+func resolveArtistImages(artists []album) ([]album, error) {
+	// Fail before searching if there is no token, later searches get it again as it can expire
+	if _, err := spotify.GetClientAccessToken(); err != nil {
+		return nil, err
+	}
+
+	var wg sync.WaitGroup
+	sem := make(chan struct{}, spotify.SearchConcurrency)
+
+	for i := range artists {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			sem <- struct{}{}
+			defer func() { <-sem }()
+
+			var images []spotify.Image
+			err := spotify.WithClientToken(func(clientAccessToken string) (err error) {
+				images, err = spotify.GetArtistImages(artists[i].Artist, clientAccessToken)
+				return err
+			})
+			if err != nil {
+				log.Print(err)
+				return
+			}
+			if len(images) == 0 {
+				return
+			}
+
+			artists[i].ImageURLS = makeSpotifyImagesURL(images)
+		}(i)
+	}
+
+	wg.Wait()
+	return artists, nil
+}
+
+// makeSpotifyImagesURL picks the smallest Spotify image that is at least as large as each
+// chart tile size, falling back to the largest image available
+func makeSpotifyImagesURL(images []spotify.Image) albumImagesURL {
+	pick := func(size int) string {
+		best := images[0]
+		for _, img := range images {
+			if img.Width >= size && (img.Width < best.Width || best.Width < size) {
+				best = img
+			}
+			if best.Width < size && img.Width > best.Width {
+				best = img
+			}
+		}
+		return best.URL
+	}
+
+	return albumImagesURL{
+		ExtraLarge: pick(300),
+		Large:      pick(largeTileSize),
+		Medium:     pick(mediumTileSize),
+		Small:      pick(34),
+	}
+}
@@ -0,0 +1,56 @@
+package chart
+
+import (
+	"strconv"
+	"testing"
+
+	"github.com/conorbros/las-tools/spotify"
+)
+
+func TestMakeSpotifyImagesURL(t *testing.T) {
+	url := func(width int) string {
+		return "https://i.scdn.co/image/" + strconv.Itoa(width)
+	}
+	images := func(widths ...int) []spotify.Image {
+		var imgs []spotify.Image
+		for _, w := range widths {
+			imgs = append(imgs, spotify.Image{URL: url(w), Width: w, Height: w})
+		}
+		return imgs
+	}
+
+	tests := []struct {
+		name   string
+		images []spotify.Image
+		want   albumImagesURL
+	}{
+		{
+			name:   "usual sizes",
+			images: images(640, 300, 64),
+			want:   albumImagesURL{ExtraLarge: url(300), Large: url(300), Medium: url(64), Small: url(64)},
+		},
+		{
+			name:   "unordered sizes",
+			images: images(64, 640, 300),
+			want:   albumImagesURL{ExtraLarge: url(300), Large: url(300), Medium: url(64), Small: url(64)},
+		},
+		{
+			name:   "smaller than requested",
+			images: images(32, 64),
+			want:   albumImagesURL{ExtraLarge: url(64), Large: url(64), Medium: url(64), Small: url(64)},
+		},
+		{
+			name:   "larger than requested",
+			images: images(999, 640),
+			want:   albumImagesURL{ExtraLarge: url(640), Large: url(640), Medium: url(640), Small: url(640)},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := makeSpotifyImagesURL(tt.images); got != tt.want {
+				t.Errorf("makeSpotifyImagesURL() = %+v; want %+v", got, tt.want)
+			}
+		})
+	}
+}
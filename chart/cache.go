@@ -0,0 +1,340 @@
+package chart
+
+import (
+	"container/list"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"image"
+	"image/png"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/conorbros/las-tools/conf"
+)
+
+// covers caches the resized covers used by every chart
+var covers = newCoverCache(
+	conf.Config.Chart.CacheMemoryEntries,
+	conf.Config.Chart.CacheDir,
+	int64(conf.Config.Chart.CacheDiskMB)*1024*1024,
+	time.Duration(conf.Config.Chart.CacheTTLHours)*time.Hour,
+)
+
+// cacheMetrics counts how the cover cache is being used
+type cacheMetrics struct {
+	MemoryHits      uint64 `json:"memoryHits"`
+	DiskHits        uint64 `json:"diskHits"`
+	Misses          uint64 `json:"misses"`
+	Expired         uint64 `json:"expired"`
+	MemoryEvictions uint64 `json:"memoryEvictions"`
+	DiskEvictions   uint64 `json:"diskEvictions"`
+	MemoryEntries   int    `json:"memoryEntries"`
+	DiskBytes       int64  `json:"diskBytes"`
+}
+
+type cacheEntry struct {
+	key     string
+	img     image.Image
+	expires time.Time
+}
+
+// coverCache is a two level cache of covers that have been downloaded and resized to a tile size.
+// Recently used covers are kept decoded in an in-memory LRU, backed by a content addressed cache of
+// PNG files on disk so covers survive restarts. Entries are keyed by the image url and tile size.
+type coverCache struct {
+	mu sync.Mutex
+
+	maxEntries int
+	ttl        time.Duration
+	lru        *list.List
+	entries    map[string]*list.Element
+
+	// dir is empty when the disk cache is turned off
+	dir          string
+	maxDiskBytes int64
+	diskBytes    int64
+
+	metrics cacheMetrics
+}
+
+// newCoverCache creates a cover cache. A negative maxDiskBytes turns the disk cache off.
+func newCoverCache(maxEntries int, dir string, maxDiskBytes int64, ttl time.Duration) *coverCache {
+	c := &coverCache{
+		maxEntries:   maxEntries,
+		ttl:          ttl,
+		lru:          list.New(),
+		entries:      make(map[string]*list.Element),
+		maxDiskBytes: maxDiskBytes,
+	}
+
+	if maxDiskBytes < 0 {
+		return c
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		log.Print("Cover disk cache turned off: ", err)
+		return c
+	}
+	c.dir = dir
+	c.diskBytes = c.diskUsage()
+	return c
+}
+
+func coverKey(url string, size int) string {
+	sum := sha256.Sum256([]byte(url + "\x00" + strconv.Itoa(size)))
+	return hex.EncodeToString(sum[:])
+}
+
+// path gets the location of a cover on disk. Covers are split into directories by the first
+// two characters of their key to keep directories small.
+func (c *coverCache) path(key string) string {
+	return filepath.Join(c.dir, key[:2], key+".png")
+}
+
+// get gets the cover for the url resized to size, if it is cached and hasn't expired
+func (c *coverCache) get(url string, size int) (image.Image, bool) {
+	key := coverKey(url, size)
+
+	c.mu.Lock()
+	if el, ok := c.entries[key]; ok {
+		entry := el.Value.(*cacheEntry)
+		if time.Now().Before(entry.expires) {
+			c.lru.MoveToFront(el)
+			c.metrics.MemoryHits++
+			c.mu.Unlock()
+			return entry.img, true
+		}
+		c.lru.Remove(el)
+		delete(c.entries, key)
+		c.metrics.Expired++
+	}
+	c.mu.Unlock()
+
+	if c.dir == "" {
+		c.miss()
+		return nil, false
+	}
+
+	img, expires, ok := c.readDisk(key)
+	if !ok {
+		c.miss()
+		return nil, false
+	}
+
+	c.mu.Lock()
+	c.metrics.DiskHits++
+	c.addMemory(key, img, expires)
+	c.mu.Unlock()
+	return img, true
+}
+
+func (c *coverCache) miss() {
+	c.mu.Lock()
+	c.metrics.Misses++
+	c.mu.Unlock()
+}
+
+// put adds a resized cover to the cache
+func (c *coverCache) put(url string, size int, img image.Image) {
+	key := coverKey(url, size)
+	expires := time.Now().Add(c.ttl)
+
+	c.mu.Lock()
+	c.addMemory(key, img, expires)
+	c.mu.Unlock()
+
+	if c.dir != "" {
+		c.writeDisk(key, img)
+	}
+}
+
+// addMemory adds an entry to the LRU, evicting the least recently used entries when it is full.
+// c.mu must be held.
+func (c *coverCache) addMemory(key string, img image.Image, expires time.Time) {
+	if el, ok := c.entries[key]; ok {
+		el.Value = &cacheEntry{key: key, img: img, expires: expires}
+		c.lru.MoveToFront(el)
+		return
+	}
+
+	c.entries[key] = c.lru.PushFront(&cacheEntry{key: key, img: img, expires: expires})
+	for c.lru.Len() > c.maxEntries {
+		oldest := c.lru.Back()
+		c.lru.Remove(oldest)
+		delete(c.entries, oldest.Value.(*cacheEntry).key)
+		c.metrics.MemoryEvictions++
+	}
+}
+
+// readDisk reads a cover from disk. Expired covers are removed.
+func (c *coverCache) readDisk(key string) (image.Image, time.Time, bool) {
+	path := c.path(key)
+
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, time.Time{}, false
+	}
+	expires := info.ModTime().Add(c.ttl)
+	if time.Now().After(expires) {
+		if os.Remove(path) == nil {
+			c.mu.Lock()
+			c.diskBytes -= info.Size()
+			c.metrics.Expired++
+			c.mu.Unlock()
+		}
+		return nil, time.Time{}, false
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, time.Time{}, false
+	}
+	defer f.Close()
+
+	img, err := png.Decode(f)
+	if err != nil {
+		log.Print(err)
+		return nil, time.Time{}, false
+	}
+	return img, expires, true
+}
+
+// writeDisk writes a cover to disk, then evicts the oldest covers if the cache is over its size limit.
+// Covers are written to a temporary file first so readers never see a partly written cover.
+func (c *coverCache) writeDisk(key string, img image.Image) {
+	path := c.path(key)
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		log.Print(err)
+		return
+	}
+
+	tmp, err := ioutil.TempFile(filepath.Dir(path), key+".tmp")
+	if err != nil {
+		log.Print(err)
+		return
+	}
+	err = png.Encode(tmp, img)
+	tmp.Close()
+	if err != nil {
+		os.Remove(tmp.Name())
+		log.Print(err)
+		return
+	}
+
+	var replaced int64
+	if info, err := os.Stat(path); err == nil {
+		replaced = info.Size()
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		os.Remove(tmp.Name())
+		log.Print(err)
+		return
+	}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		return
+	}
+
+	c.mu.Lock()
+	c.diskBytes += info.Size() - replaced
+	over := c.diskBytes > c.maxDiskBytes
+	c.mu.Unlock()
+
+	if over {
+		c.evictDisk()
+	}
+}
+
+type cachedFile struct {
+	path    string
+	size    int64
+	modTime time.Time
+}
+
+// diskFiles lists every cover in the disk cache
+func (c *coverCache) diskFiles() []cachedFile {
+	var files []cachedFile
+	filepath.Walk(c.dir, func(path string, info os.FileInfo, err error) error {
+		if err == nil && !info.IsDir() && filepath.Ext(path) == ".png" {
+			files = append(files, cachedFile{path: path, size: info.Size(), modTime: info.ModTime()})
+		}
+		return nil
+	})
+	return files
+}
+
+func (c *coverCache) diskUsage() int64 {
+	var total int64
+	for _, f := range c.diskFiles() {
+		total += f.size
+	}
+	return total
+}
+
+// evictDisk removes the oldest covers until the disk cache is back under 90% of its size limit,
+// so a full cache isn't scanned again on every write
+func (c *coverCache) evictDisk() {
+	files := c.diskFiles()
+	sort.Slice(files, func(i, j int) bool {
+		return files[i].modTime.Before(files[j].modTime)
+	})
+
+	var total int64
+	for _, f := range files {
+		total += f.size
+	}
+
+	target := c.maxDiskBytes / 10 * 9
+	var evicted uint64
+	for _, f := range files {
+		if total <= target {
+			break
+		}
+		if os.Remove(f.path) == nil {
+			total -= f.size
+			evicted++
+		}
+	}
+
+	c.mu.Lock()
+	c.diskBytes = total
+	c.metrics.DiskEvictions += evicted
+	c.mu.Unlock()
+}
+
+// stats gets a snapshot of the cache's metrics
+func (c *coverCache) stats() cacheMetrics {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	m := c.metrics
+	m.MemoryEntries = c.lru.Len()
+	m.DiskBytes = c.diskBytes
+	return m
+}
+
+// CacheStatsHandler returns the cover cache's hit, miss and eviction counts as JSON
+func CacheStatsHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	jsonValue, err := json.Marshal(covers.stats())
+	if err != nil {
+		http.Error(w, "Could not get the cache stats", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write(jsonValue)
+}
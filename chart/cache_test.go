@@ -0,0 +1,145 @@
+package chart
+
+import (
+	"context"
+	"image"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/conorbros/las-tools/conf"
+)
+
+// TestMain gives the tests their own disk cache so they never get covers cached by earlier runs,
+// whose test servers may have had the same URLs
+func TestMain(m *testing.M) {
+	dir, err := ioutil.TempDir("", "covers")
+	if err != nil {
+		log.Fatal(err)
+	}
+	covers = newCoverCache(
+		conf.Config.Chart.CacheMemoryEntries,
+		dir,
+		int64(conf.Config.Chart.CacheDiskMB)*1024*1024,
+		time.Duration(conf.Config.Chart.CacheTTLHours)*time.Hour,
+	)
+
+	code := m.Run()
+	os.RemoveAll(dir)
+	os.Exit(code)
+}
+
+func tempCacheDir(t *testing.T) string {
+	dir, err := ioutil.TempDir("", "covers")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return dir
+}
+
+func TestCoverCacheEvictsLeastRecentlyUsed(t *testing.T) {
+	c := newCoverCache(2, "", -1, time.Hour)
+	img := image.NewRGBA(image.Rect(0, 0, 1, 1))
+
+	c.put("a", 1, img)
+	c.put("b", 1, img)
+	c.get("a", 1)
+	c.put("c", 1, img)
+
+	if _, ok := c.get("b", 1); ok {
+		t.Error("b is still cached; want it evicted")
+	}
+	if _, ok := c.get("a", 1); !ok {
+		t.Error("a was evicted; want it kept as it was used recently")
+	}
+	if got := c.stats().MemoryEvictions; got != 1 {
+		t.Errorf("MemoryEvictions = %d; want 1", got)
+	}
+}
+
+func TestCoverCacheExpires(t *testing.T) {
+	c := newCoverCache(10, "", -1, -time.Second)
+	c.put("a", 1, image.NewRGBA(image.Rect(0, 0, 1, 1)))
+
+	if _, ok := c.get("a", 1); ok {
+		t.Error("expired cover was returned")
+	}
+	if got := c.stats().Expired; got != 1 {
+		t.Errorf("Expired = %d; want 1", got)
+	}
+}
+
+func TestCoverCacheDisk(t *testing.T) {
+	dir := tempCacheDir(t)
+	defer os.RemoveAll(dir)
+
+	first := newCoverCache(10, dir, 1024*1024, time.Hour)
+	first.put("a", largeTileSize, image.NewRGBA(image.Rect(0, 0, largeTileSize, largeTileSize)))
+
+	// A new cache with the same directory, as after a restart
+	second := newCoverCache(10, dir, 1024*1024, time.Hour)
+	img, ok := second.get("a", largeTileSize)
+	if !ok {
+		t.Fatal("cover was not read back from disk")
+	}
+	if img.Bounds().Dx() != largeTileSize {
+		t.Errorf("cover from disk is %d wide; want %d", img.Bounds().Dx(), largeTileSize)
+	}
+	if _, ok := second.get("a", mediumTileSize); ok {
+		t.Error("cover was found for a different tile size")
+	}
+	if got := second.stats().DiskHits; got != 1 {
+		t.Errorf("DiskHits = %d; want 1", got)
+	}
+}
+
+func TestCoverCacheDiskLimit(t *testing.T) {
+	dir := tempCacheDir(t)
+	defer os.RemoveAll(dir)
+
+	c := newCoverCache(1, dir, 1, time.Hour)
+	c.put("a", 1, image.NewRGBA(image.Rect(0, 0, 1, 1)))
+	c.put("b", 1, image.NewRGBA(image.Rect(0, 0, 1, 1)))
+
+	if got := c.stats().DiskEvictions; got != 2 {
+		t.Errorf("DiskEvictions = %d; want 2", got)
+	}
+	if got := c.stats().DiskBytes; got != 0 {
+		t.Errorf("DiskBytes = %d; want 0", got)
+	}
+}
+
+func TestDownloadImagesUsesCache(t *testing.T) {
+	server, hits := coverServer(http.StatusOK, 0)
+	defer server.Close()
+
+	chart := func() []album {
+		albums := make([]album, 10*10)
+		for i := range albums {
+			albums[i].ImageURLS.Large = server.URL + "/" + strconv.Itoa(i) + ".png"
+		}
+		return albums
+	}
+
+	downloadImages(context.Background(), chart(), "Large", nil)
+	if *hits != 100 {
+		t.Fatalf("first chart requested %d covers; want 100", *hits)
+	}
+
+	albums := chart()
+	if errIndexes := downloadImages(context.Background(), albums, "Large", nil); len(errIndexes) != 0 {
+		t.Fatalf("downloadImages() failed for %v", errIndexes)
+	}
+	if *hits != 100 {
+		t.Errorf("second chart requested %d covers; want 0", *hits-100)
+	}
+	for i, a := range albums {
+		if a.Image == nil {
+			t.Fatalf("album %d has no image", i)
+		}
+	}
+}
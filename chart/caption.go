@@ -0,0 +1,212 @@
+package chart
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"sync"
+
+	"golang.org/x/image/font"
+	"golang.org/x/image/font/gofont/goregular"
+	"golang.org/x/image/font/opentype"
+	"golang.org/x/image/math/fixed"
+)
+
+// Caption modes accepted by the captions query parameter. Overlay captions are printed over
+// the bottom of each tile and legend captions are listed in a panel to the right of the chart.
+const (
+	captionsNone    = "none"
+	captionsOverlay = "overlay"
+	captionsLegend  = "legend"
+)
+
+// The Go font is compiled into the binary so no font files need to be deployed
+var (
+	captionFont     *opentype.Font
+	captionFontErr  error
+	captionFontOnce sync.Once
+)
+
+// captionBand is the translucent background drawn behind overlay captions
+var captionBand = image.NewUniform(color.RGBA{0, 0, 0, 170})
+
+func loadCaptionFont() (*opentype.Font, error) {
+	captionFontOnce.Do(func() {
+		captionFont, captionFontErr = opentype.Parse(goregular.TTF)
+	})
+	return captionFont, captionFontErr
+}
+
+// newCaptionFace creates a face of the caption font with the given height in pixels
+func newCaptionFace(size float64) (font.Face, error) {
+	f, err := loadCaptionFont()
+	if err != nil {
+		return nil, err
+	}
+	return opentype.NewFace(f, &opentype.FaceOptions{
+		Size:    size,
+		DPI:     72,
+		Hinting: font.HintingFull,
+	})
+}
+
+// captionText gets the "Artist – Album" text for a tile. Artist charts have no title.
+func captionText(a album) string {
+	if a.Title == "" {
+		return a.Artist
+	}
+	return a.Artist + " – " + a.Title
+}
+
+// playcountText gets the play count text for a tile
+func playcountText(a album) string {
+	if a.Playcount == 1 {
+		return "1 play"
+	}
+	return fmt.Sprintf("%d plays", a.Playcount)
+}
+
+// drawCaptions prints the captions for the arranged albums onto the chart. Legend captions
+// need extra space so a new, wider image is returned in that mode.
+func drawCaptions(chart *image.RGBA, albums []album, x, tile int, mode string, playcounts bool) (*image.RGBA, error) {
+	switch mode {
+	case captionsOverlay:
+		return chart, drawOverlayCaptions(chart, albums, x, tile, playcounts)
+	case captionsLegend:
+		return drawLegendCaptions(chart, albums, tile, playcounts)
+	}
+	return chart, nil
+}
+
+// drawOverlayCaptions prints each album's caption over the bottom of its tile
+func drawOverlayCaptions(chart *image.RGBA, albums []album, x, tile int, playcounts bool) error {
+	size := float64(tile) / 14
+	if size < 7 {
+		size = 7
+	}
+	face, err := newCaptionFace(size)
+	if err != nil {
+		return err
+	}
+	defer face.Close()
+
+	lineHeight := face.Metrics().Height.Ceil()
+	padding := tile / 32
+
+	for i, a := range albums {
+		lines := []string{captionText(a)}
+		if playcounts {
+			lines = append(lines, playcountText(a))
+		}
+
+		left := (i % x) * tile
+		bottom := (i/x + 1) * tile
+
+		band := image.Rect(left, bottom-len(lines)*lineHeight-2*padding, left+tile, bottom)
+		draw.Draw(chart, band, captionBand, image.Point{}, draw.Over)
+
+		for l, line := range lines {
+			baseline := band.Min.Y + padding + l*lineHeight + face.Metrics().Ascent.Ceil()
+			drawText(chart, face, line, left+padding, baseline, tile-2*padding)
+		}
+	}
+	return nil
+}
+
+// drawLegendCaptions adds a panel to the right of the chart listing the albums, numbered
+// left to right, top to bottom. Long lists wrap into extra columns.
+func drawLegendCaptions(chart *image.RGBA, albums []album, tile int, playcounts bool) (*image.RGBA, error) {
+	size := float64(tile) / 12
+	if size > 14 {
+		size = 14
+	}
+	if size < 8 {
+		size = 8
+	}
+	face, err := newCaptionFace(size)
+	if err != nil {
+		return nil, err
+	}
+	defer face.Close()
+
+	lineHeight := face.Metrics().Height.Ceil()
+	padding := lineHeight
+
+	lines := legendLines(albums, playcounts)
+	var widest fixed.Int26_6
+	for _, line := range lines {
+		if w := font.MeasureString(face, line); w > widest {
+			widest = w
+		}
+	}
+
+	bounds := chart.Bounds()
+
+	// Keep very long titles from making the columns too wide
+	columnWidth := widest.Ceil() + padding
+	if max := 3 * tile; columnWidth > max {
+		columnWidth = max
+	}
+	perColumn := (bounds.Dy() - 2*padding) / lineHeight
+	if perColumn < 1 {
+		perColumn = 1
+	}
+	columns := (len(lines) + perColumn - 1) / perColumn
+
+	captioned := image.NewRGBA(image.Rect(0, 0, bounds.Dx()+columns*columnWidth+padding, bounds.Dy()))
+	draw.Draw(captioned, captioned.Bounds(), image.Black, image.Point{}, draw.Src)
+	draw.Draw(captioned, bounds.Sub(bounds.Min), chart, bounds.Min, draw.Src)
+
+	for i, line := range lines {
+		left := bounds.Dx() + padding + (i/perColumn)*columnWidth
+		baseline := padding + (i%perColumn)*lineHeight + face.Metrics().Ascent.Ceil()
+		drawText(captioned, face, line, left, baseline, columnWidth-padding)
+	}
+	return captioned, nil
+}
+
+// legendLines gets the legend caption for each of the arranged albums, numbered in the order
+// their tiles appear, left to right, top to bottom
+func legendLines(albums []album, playcounts bool) []string {
+	lines := make([]string, len(albums))
+	for i, a := range albums {
+		lines[i] = fmt.Sprintf("%d. %s", i+1, captionText(a))
+		if playcounts {
+			lines[i] += " (" + playcountText(a) + ")"
+		}
+	}
+	return lines
+}
+
+// drawText prints text in white starting at the baseline, shortening it with an ellipsis
+// if it is wider than maxWidth pixels
+func drawText(dst draw.Image, face font.Face, text string, x, baseline, maxWidth int) {
+	text = truncateText(face, text, maxWidth)
+
+	d := font.Drawer{
+		Dst:  dst,
+		Src:  image.White,
+		Face: face,
+		Dot:  fixed.P(x, baseline),
+	}
+	d.DrawString(text)
+}
+
+// truncateText shortens text until it fits within maxWidth pixels
+func truncateText(face font.Face, text string, maxWidth int) string {
+	max := fixed.I(maxWidth)
+	if font.MeasureString(face, text) <= max {
+		return text
+	}
+
+	runes := []rune(text)
+	for len(runes) > 0 {
+		runes = runes[:len(runes)-1]
+		shortened := string(runes) + "…"
+		if font.MeasureString(face, shortened) <= max {
+			return shortened
+		}
+	}
+	return ""
+}
@@ -0,0 +1,57 @@
+package chart
+
+import (
+	"image"
+	"reflect"
+	"strconv"
+	"testing"
+)
+
+func TestLegendLinesFollowTiles(t *testing.T) {
+	albums := make([]album, 6)
+	for i := range albums {
+		albums[i] = album{Artist: "Artist", Title: "Rank " + strconv.Itoa(i+1), Playcount: uint64(6 - i)}
+	}
+	albums[5].Playcount = 1
+
+	// The diagonal layout fills the tiles of a 3x2 grid with ranks 1, 2, 5, 3, 4, 6
+	arrangeAlbums(albums, 3, 2, diagonalLayout)
+
+	want := []string{
+		"1. Artist – Rank 1 (6 plays)",
+		"2. Artist – Rank 2 (5 plays)",
+		"3. Artist – Rank 5 (2 plays)",
+		"4. Artist – Rank 3 (4 plays)",
+		"5. Artist – Rank 4 (3 plays)",
+		"6. Artist – Rank 6 (1 play)",
+	}
+	if got := legendLines(albums, true); !reflect.DeepEqual(got, want) {
+		t.Errorf("legendLines() = %q; want %q", got, want)
+	}
+
+	// Artist charts have no titles
+	if got := legendLines([]album{{Artist: "Artist"}}, false); !reflect.DeepEqual(got, []string{"1. Artist"}) {
+		t.Errorf("legendLines() for an artist = %q; want [\"1. Artist\"]", got)
+	}
+}
+
+func TestDrawCaptionsLegendWidensChart(t *testing.T) {
+	chart := image.NewRGBA(image.Rect(0, 0, 2*mediumTileSize, mediumTileSize))
+	albums := []album{{Artist: "A", Title: "1"}, {Artist: "B", Title: "2"}}
+
+	captioned, err := drawCaptions(chart, albums, 2, mediumTileSize, captionsLegend, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if b := captioned.Bounds(); b.Dx() <= chart.Bounds().Dx() || b.Dy() != chart.Bounds().Dy() {
+		t.Errorf("legend chart is %v; want wider than %v and as tall", b, chart.Bounds())
+	}
+
+	overlaid, err := drawCaptions(chart, albums, 2, mediumTileSize, captionsOverlay, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if overlaid.Bounds() != chart.Bounds() {
+		t.Errorf("overlay chart is %v; want %v", overlaid.Bounds(), chart.Bounds())
+	}
+}
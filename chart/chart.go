@@ -2,25 +2,23 @@ package chart
 
 import (
 	"bytes"
-	"encoding/json"
+	"context"
 	"errors"
 	"fmt"
 	"html/template"
 	"image"
 	"image/color"
-	"image/jpeg"
-	"io/ioutil"
 	"log"
 	"math"
 	"net/http"
+	"net/url"
 	"os"
-	"sort"
 	"strconv"
-	"strings"
 	"sync"
 	"time"
 
 	"github.com/conorbros/las-tools/conf"
+	"github.com/conorbros/las-tools/lastfm"
 	gim "github.com/ozankasikci/go-image-merge"
 )
 
@@ -50,9 +48,10 @@ type albumImagesURL struct {
 }
 
 type albumColor struct {
-	Hue   float64
-	Sat   float64
-	Value float64
+	Hue       float64
+	Sat       float64
+	Value     float64
+	Luminance float64
 }
 
 // Album represents an album to be added to the chart
@@ -75,35 +74,256 @@ func PageHandler(w http.ResponseWriter, r *http.Request) {
 	tpl.Execute(w, nil)
 }
 
-func extractQuery(r *http.Request) (username string, x, y int, err error) {
-	usernameQ, ok := r.URL.Query()["username"]
+// validPeriods are the time periods accepted by the Last.fm user.getTop* methods
+var validPeriods = map[string]bool{
+	"overall": true,
+	"7day":    true,
+	"1month":  true,
+	"3month":  true,
+	"6month":  true,
+	"12month": true,
+}
+
+// Chart types. An albums chart is made from the user's top albums, an artists chart
+// from their top artists and a tracks chart from the covers of their top tracks.
+const (
+	chartTypeAlbums  = "albums"
+	chartTypeArtists = "artists"
+	chartTypeTracks  = "tracks"
+)
+
+// Ways of handling albums without art accepted by the missing query parameter. Skipped
+// albums are left out of the chart and placeholder albums are given a generated cover.
+const (
+	missingSkip        = "skip"
+	missingPlaceholder = "placeholder"
+)
+
+// dateLayout is the format of the from and to query parameters
+const dateLayout = "2006-01-02"
+
+// Limits on the size of a chart. The largest size offered on the chart page is 32x18.
+const (
+	maxChartSide  = 50
+	maxChartCells = 1000
+)
+
+// queryError is returned by extractQuery when a parameter is present but invalid.
+// Its message is safe to show to the user.
+type queryError struct {
+	msg string
+}
+
+func (e *queryError) Error() string {
+	return e.msg
+}
+
+// chartQuery holds the parameters of a chart request
+type chartQuery struct {
+	Username     string
+	X            int
+	Y            int
+	Type         string
+	Period       string
+	From         time.Time
+	To           time.Time
+	Collapse     bool
+	Order        orderStrategy
+	Layout       layout
+	ColorMode    colorExtractor
+	Captions     string
+	Playcounts   bool
+	Format       outputFormat
+	Placeholders bool
+}
+
+// isDateRange reports whether the chart should be built from a custom date range
+// rather than one of the Last.fm periods
+func (q chartQuery) isDateRange() bool {
+	return !q.From.IsZero()
+}
+
+func extractQuery(values url.Values) (q chartQuery, err error) {
+	usernameQ, ok := values["username"]
 	if !ok || len(usernameQ) == 0 || usernameQ[0] == "" {
 		err = errors.New("Missing username")
 		return
 	}
-	xQ, ok := r.URL.Query()["x"]
+	xQ, ok := values["x"]
 	if !ok {
 		err = errors.New("Missing X")
 		return
 	}
-	yQ, ok := r.URL.Query()["y"]
+	yQ, ok := values["y"]
 	if !ok {
 		err = errors.New("Missing y")
 		return
 	}
 
-	username = usernameQ[0]
+	q.Username = usernameQ[0]
+
+	if q.X, err = strconv.Atoi(xQ[0]); err != nil {
+		err = errors.New("X is not an int")
+		return
+	}
+	if q.Y, err = strconv.Atoi(yQ[0]); err != nil {
+		err = errors.New("Y is not an int")
+		return
+	}
+	if q.X < 1 || q.Y < 1 || q.X > maxChartSide || q.Y > maxChartSide || q.X*q.Y > maxChartCells {
+		err = &queryError{fmt.Sprintf("X and y must be from 1 to %d, with at most %d covers in the chart", maxChartSide, maxChartCells)}
+		return
+	}
+
+	// Default to an all time chart when no period is supplied
+	q.Period = values.Get("period")
+	if q.Period == "" {
+		q.Period = "overall"
+	}
+	if !validPeriods[q.Period] {
+		err = &queryError{"Invalid period. Must be one of overall, 7day, 1month, 3month, 6month or 12month"}
+		return
+	}
+
+	q.Type = values.Get("type")
+	if q.Type == "" {
+		q.Type = chartTypeAlbums
+	}
+	if q.Type != chartTypeAlbums && q.Type != chartTypeArtists && q.Type != chartTypeTracks {
+		err = &queryError{"Invalid type. Must be one of albums, artists or tracks"}
+		return
+	}
+
+	// Collapsing duplicate covers only applies to tracks charts
+	if collapseQ := values.Get("collapse"); collapseQ != "" {
+		if q.Collapse, err = strconv.ParseBool(collapseQ); err != nil {
+			err = &queryError{"Collapse must be true or false"}
+			return
+		}
+	}
+
+	layoutQ := values.Get("layout")
+
+	// Radial charts put the start of the sequence in the middle so default to the most played albums there
+	orderQ := values.Get("order")
+	if orderQ == "" && layoutQ == layoutRadial {
+		orderQ = orderPlaycount
+	} else if orderQ == "" {
+		orderQ = orderHue
+	}
+	seed := time.Now().UnixNano()
+	if seedQ := values.Get("seed"); seedQ != "" {
+		if seed, err = strconv.ParseInt(seedQ, 10, 64); err != nil {
+			err = &queryError{"Seed must be an int"}
+			return
+		}
+	}
+	if q.Order, err = newOrderStrategy(orderQ, seed); err != nil {
+		return
+	}
+
+	// Colour orders are laid out along the diagonals by default so similar colours form bands,
+	// other orders read left to right like a regular top albums chart
+	if layoutQ == "" && q.Order.usesColor() {
+		layoutQ = layoutDiagonal
+	} else if layoutQ == "" {
+		layoutQ = layoutRows
+	}
+	if q.Layout, ok = layouts[layoutQ]; !ok {
+		err = &queryError{"Invalid layout. Must be one of diagonal, rows, spiral, hilbert or radial"}
+		return
+	}
+
+	colorModeQ := values.Get("colormode")
+	if colorModeQ == "" {
+		colorModeQ = colorModeAverage
+	}
+	if q.ColorMode, ok = colorModes[colorModeQ]; !ok {
+		err = &queryError{"Invalid colormode. Must be one of average or dominant"}
+		return
+	}
+
+	q.Captions = values.Get("captions")
+	if q.Captions == "" {
+		q.Captions = captionsNone
+	}
+	if q.Captions != captionsNone && q.Captions != captionsOverlay && q.Captions != captionsLegend {
+		err = &queryError{"Invalid captions. Must be one of none, overlay or legend"}
+		return
+	}
+	if playcountsQ := values.Get("playcounts"); playcountsQ != "" {
+		if q.Playcounts, err = strconv.ParseBool(playcountsQ); err != nil {
+			err = &queryError{"Playcounts must be true or false"}
+			return
+		}
+	}
 
-	if x, err = strconv.Atoi(xQ[0]); err != nil {
+	formatQ := values.Get("format")
+	if formatQ == "" {
+		formatQ = formatJPEG
+	}
+	quality := defaultJPEGQuality
+	if qualityQ := values.Get("quality"); qualityQ != "" {
+		if quality, err = strconv.Atoi(qualityQ); err != nil || quality < 1 || quality > 100 {
+			err = &queryError{"Quality must be an int from 1 to 100"}
+			return
+		}
+	}
+	if q.Format, err = newOutputFormat(formatQ, quality); err != nil {
+		return
+	}
 
-		errors.New("X is not an int")
+	switch values.Get("missing") {
+	case "", missingSkip:
+		q.Placeholders = false
+	case missingPlaceholder:
+		q.Placeholders = true
+	default:
+		err = &queryError{"Invalid missing. Must be one of skip or placeholder"}
 		return
 	}
-	if y, err = strconv.Atoi(yQ[0]); err != nil {
-		errors.New("Y is not an int")
+
+	if q.From, q.To, err = extractDateRange(values); err != nil {
+		return
+	}
+	if q.isDateRange() && q.Type != chartTypeAlbums {
+		err = &queryError{"Date range charts can only be made from albums"}
+		return
+	}
+	return
+}
+
+// extractDateRange parses the optional from and to parameters. Both must be supplied
+// together, and to is inclusive so a range of 2024-06-01 to 2024-08-31 covers all of August.
+func extractDateRange(values url.Values) (from, to time.Time, err error) {
+	fromQ := values.Get("from")
+	toQ := values.Get("to")
+	if fromQ == "" && toQ == "" {
+		return
+	}
+	if fromQ == "" || toQ == "" {
+		err = &queryError{"Both from and to must be supplied for a date range chart"}
 		return
 	}
 
+	if from, err = time.Parse(dateLayout, fromQ); err != nil {
+		err = &queryError{"From must be a date in the format YYYY-MM-DD"}
+		return
+	}
+	if to, err = time.Parse(dateLayout, toQ); err != nil {
+		err = &queryError{"To must be a date in the format YYYY-MM-DD"}
+		return
+	}
+	to = to.AddDate(0, 0, 1)
+
+	if !from.Before(to) {
+		err = &queryError{"From must be on or before to"}
+		return
+	}
+	if to.Sub(from) > maxDateRange {
+		err = &queryError{"Date ranges can be at most two years long"}
+		return
+	}
 	return
 }
 
@@ -128,26 +348,96 @@ func GenerateChartHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	username, x, y, err := extractQuery(r)
+	q, err := extractQuery(r.URL.Query())
+	if qErr, ok := err.(*queryError); ok {
+		http.Error(w, qErr.Error(), http.StatusBadRequest)
+		return
+	}
 	if err != nil {
 		http.Error(w, "Bad request. Try reloading the page.", http.StatusBadRequest)
 		return
 	}
 
-	albums, err := getLastFmTopAlbums(username, x*y)
+	chart, err := generateChart(r.Context(), q, nil)
+	if r.Context().Err() != nil {
+		// The user has gone so there is no one to send the chart to
+		return
+	}
 	if err != nil {
-		http.Error(w, "There was an error getting the albums. Try again or contact me.", http.StatusInternalServerError)
+		writeChartError(w, err)
 		return
 	}
 
-	if len(albums) <= 0 {
-		http.Error(w, "No albums were found. Check the Last.fm username", http.StatusBadRequest)
+	writeChart(w, q, chart)
+}
+
+// writeChart writes an encoded chart to the response
+func writeChart(w http.ResponseWriter, q chartQuery, chart []byte) {
+	w.Header().Set("Content-type", q.Format.ContentType)
+	w.Header().Set("Content-Disposition", fmt.Sprintf("inline; filename=%q", q.filename()))
+	w.Header().Set("Content-Length", strconv.Itoa(len(chart)))
+	w.Write(chart)
+}
+
+// chartError is an error from generating a chart, with a message that is safe to show the user
+type chartError struct {
+	status int
+	msg    string
+}
+
+func (e *chartError) Error() string {
+	return e.msg
+}
+
+// writeChartError responds with the status and message of a chart error
+func writeChartError(w http.ResponseWriter, err error) {
+	if cErr, ok := err.(*chartError); ok {
+		http.Error(w, cErr.msg, cErr.status)
 		return
 	}
+	http.Error(w, "There was an error generating the chart. Try again or contact me.", http.StatusInternalServerError)
+}
+
+// Stages of generating a chart, reported to the progress func
+const (
+	stageFetching    = "fetching albums"
+	stageDownloading = "downloading covers"
+	stageSorting     = "sorting"
+	stageMerging     = "merging"
+	stageEncoding    = "encoding"
+)
+
+// progressFunc is told which stage chart generation has reached. Done and total count
+// the covers downloaded so far during the downloading stage and are zero otherwise.
+type progressFunc func(stage string, done, total int)
+
+// generateChart generates and encodes the chart described by the query. Errors that should be
+// shown to the user are returned as a *chartError.
+func generateChart(ctx context.Context, q chartQuery, progress progressFunc) ([]byte, error) {
+	if progress == nil {
+		progress = func(string, int, int) {}
+	}
+	x, y := q.X, q.Y
+
+	progress(stageFetching, 0, 0)
+	albums, err := getChartItems(q)
+	if lastfm.IsInvalidParameters(err) {
+		return nil, &chartError{http.StatusBadRequest, "No albums were found. Check the Last.fm username"}
+	}
+	if err != nil {
+		return nil, &chartError{http.StatusInternalServerError, "There was an error getting the albums. Try again or contact me."}
+	}
+
+	if !q.Placeholders {
+		albums = removeAlbumsWithoutArt(albums)
+	}
+
+	if len(albums) <= 0 {
+		return nil, &chartError{http.StatusBadRequest, "No albums were found. Check the Last.fm username"}
+	}
 
 	if len(albums) < x*y {
-		http.Error(w, "Not enough albums to generate a chart. Try choosing a smaller size.", http.StatusBadRequest)
-		return
+		return nil, &chartError{http.StatusBadRequest, "Not enough albums to generate a chart. Try choosing a smaller size."}
 	}
 
 	var size string
@@ -157,19 +447,27 @@ func GenerateChartHandler(w http.ResponseWriter, r *http.Request) {
 		size = "Large"
 	}
 
-	albums, err = getAlbumCovers(albums, x*y, size)
+	albums, err = getAlbumCovers(ctx, albums, x*y, size, q.Placeholders, progress)
+	if ctx.Err() != nil {
+		return nil, ctx.Err()
+	}
 	if err != nil {
-		http.Error(w, "Download to failed images. Try again or contact me.", http.StatusInternalServerError)
-		return
+		return nil, &chartError{http.StatusInternalServerError, "Download to failed images. Try again or contact me."}
 	}
 
-	err = sortAlbumsByHsv(albums)
-	if err != nil {
-		http.Error(w, "There was an error generating the chart. Try again or contact me.", http.StatusInternalServerError)
+	if len(albums) < x*y {
+		return nil, &chartError{http.StatusBadRequest, "Not enough album covers could be downloaded. Try a smaller size or missing=placeholder."}
+	}
+
+	progress(stageSorting, 0, 0)
+	if q.Order.usesColor() {
+		setAlbumColors(albums, q.ColorMode)
 	}
+	q.Order.sort(albums)
 
-	rearrangeAlbums(albums, x, y)
+	arrangeAlbums(albums, x, y, q.Layout)
 
+	progress(stageMerging, 0, 0)
 	var grids = make([]*gim.Grid, len(albums))
 
 	for i, a := range albums {
@@ -182,51 +480,64 @@ func GenerateChartHandler(w http.ResponseWriter, r *http.Request) {
 
 	chart, err := gim.New(grids, x, y).Merge()
 	if err != nil {
-		http.Error(w, "There was an error generating the image. Try again or contact me.", http.StatusInternalServerError)
+		return nil, &chartError{http.StatusInternalServerError, "There was an error generating the image. Try again or contact me."}
 	}
 
-	buffer := new(bytes.Buffer)
-	jpeg.Encode(buffer, chart, &jpeg.Options{Quality: 80})
-
-	w.Header().Set("Content-type", "image/jpeg")
-	w.Header().Set("Content-Length", strconv.Itoa(len(buffer.Bytes())))
-	w.Write(buffer.Bytes())
-}
-
-func getLastFmTopAlbums(username string, count int) ([]album, error) {
-	// add 50 to the count as a buffer against downloads that fail
-	urlParams := fmt.Sprintf("&user=%s&api_key=%s&format=json&period=%s&limit=%d", username, conf.Config.LastFm.APIKey, "overall", count+50)
-	url := conf.Config.LastFm.UserTopAlbumsEndpoint + urlParams
-
-	req, err := http.NewRequest(http.MethodGet, url, strings.NewReader(""))
+	chart, err = drawCaptions(chart, albums, x, tileSize(size), q.Captions, q.Playcounts)
 	if err != nil {
-		return nil, err
+		return nil, &chartError{http.StatusInternalServerError, "There was an error adding the captions. Try again or contact me."}
 	}
 
-	client := http.Client{
-		Timeout: time.Duration(60 * time.Second),
+	progress(stageEncoding, 0, 0)
+	buffer := new(bytes.Buffer)
+	if err = q.Format.Encode(buffer, chart); err != nil {
+		return nil, &chartError{http.StatusInternalServerError, "There was an error encoding the image. Try again or contact me."}
 	}
-	res, err := client.Do(req)
-	if err != nil {
-		return nil, err
+	return buffer.Bytes(), nil
+}
+
+// getChartItems gets the albums or artists the chart will be made from, in rank order
+func getChartItems(q chartQuery) ([]album, error) {
+	count := q.X * q.Y
+	switch {
+	case q.Type == chartTypeArtists:
+		return getLastFmTopArtists(q.Username, count, q.Period)
+	case q.Type == chartTypeTracks:
+		return getLastFmTopTracks(q.Username, count, q.Period, q.Collapse)
+	case q.isDateRange():
+		return getLastFmDateRangeAlbums(q.Username, count, q.From, q.To)
+	default:
+		return getLastFmTopAlbums(q.Username, count, q.Period)
 	}
+}
 
-	defer res.Body.Close()
-	body, err := ioutil.ReadAll(res.Body)
-	if err != nil {
-		return nil, err
+// lastFmMaxLimit is the largest limit Last.fm accepts for a single page of results
+const lastFmMaxLimit = 1000
+
+// lastFmLimit caps the number of results asked for in one request at lastFmMaxLimit, as
+// Last.fm rejects larger limits
+func lastFmLimit(n int) int {
+	if n > lastFmMaxLimit {
+		return lastFmMaxLimit
 	}
+	return n
+}
+
+func getLastFmTopAlbums(username string, count int, period string) ([]album, error) {
+	// add 50 to the count as a buffer against downloads that fail
+	urlParams := fmt.Sprintf("&user=%s&api_key=%s&format=json&period=%s&limit=%d", username, conf.Config.LastFm.APIKey, period, lastFmLimit(count+50))
+	url := conf.Config.LastFm.UserTopAlbumsEndpoint + urlParams
 
 	var response topAlbumsResponse
-	err = json.Unmarshal(body, &response)
-	if err != nil {
+	if err := lastfm.GetCachedJSON(url, &response); err != nil {
 		return nil, err
 	}
 	var albums []album
 	for _, a := range response.Topalbums.Album {
+		// Albums without art are kept so they can be given a placeholder
 		images, err := makeAlbumImagesURL(a.Image)
 		if err != nil {
-			continue
+			images = albumImagesURL{}
 		}
 		playcount, err := strconv.ParseUint(a.Playcount, 10, 64)
 		if err != nil {
@@ -243,36 +554,61 @@ func getLastFmTopAlbums(username string, count int) ([]album, error) {
 	return albums, nil
 }
 
+// makeAlbumImagesURL picks out the image sizes used for charts. Any other sizes
+// (such as the "mega" size returned by album.getInfo) are ignored.
 func makeAlbumImagesURL(images []imageResponse) (albumImagesURL, error) {
 	var albumImages albumImagesURL
 	for _, img := range images {
-
-		size := img.Size
-		urlExists := img.Text != ""
-
-		switch true {
-		case size == "small" && urlExists:
+		switch img.Size {
+		case "small":
 			albumImages.Small = img.Text
 
-		case size == "medium" && urlExists:
+		case "medium":
 			albumImages.Medium = img.Text
 
-		case size == "large" && urlExists:
+		case "large":
 			albumImages.Large = img.Text
 
-		case size == "extralarge" && urlExists:
+		case "extralarge":
 			albumImages.ExtraLarge = img.Text
-
-		default:
-			return albumImages, errors.New("No album art detected")
 		}
 	}
 
+	if albumImages.Small == "" || albumImages.Medium == "" || albumImages.Large == "" || albumImages.ExtraLarge == "" {
+		return albumImages, errors.New("No album art detected")
+	}
+
 	return albumImages, nil
 }
 
-func getAlbumCovers(albums []album, count int, size string) ([]album, error) {
-	errIndexes := downloadImages(albums, size)
+// getAlbumCovers downloads the covers for the albums and returns the first count albums. Albums whose
+// covers fail to download are dropped, unless placeholders is true in which case they are given a
+// generated placeholder cover so every album keeps its place in the chart. Downloads stop early
+// with the context's error if the context is cancelled.
+func getAlbumCovers(ctx context.Context, albums []album, count int, size string, placeholders bool, progress progressFunc) ([]album, error) {
+	if placeholders {
+		if len(albums) > count {
+			albums = albums[:count]
+		}
+
+		errIndexes := downloadImages(ctx, albums, size, progress)
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		for _, i := range errIndexes {
+			img, err := placeholderImage(albums[i], tileSize(size))
+			if err != nil {
+				return nil, err
+			}
+			albums[i].Image = &img
+		}
+		return albums, nil
+	}
+
+	errIndexes := downloadImages(ctx, albums, size, progress)
+	if ctx.Err() != nil {
+		return nil, ctx.Err()
+	}
 
 	var newAlbums []album
 	for i := 0; i < len(albums); i++ {
@@ -287,67 +623,20 @@ func getAlbumCovers(albums []album, count int, size string) ([]album, error) {
 	return newAlbums, nil
 }
 
-func downloadImages(albums []album, size string) []int {
-	errs := make(chan int)
-	success := make(chan int)
-	wgDone := make(chan bool)
-
-	var wg sync.WaitGroup
-
-	for i := 0; i < len(albums); i++ {
-		wg.Add(1)
-		go func(i int, wg *sync.WaitGroup) {
-			defer wg.Done()
-
-			var url string
-			if size == "Large" {
-				url = albums[i].ImageURLS.Large
-			} else {
-				url = albums[i].ImageURLS.Medium
-			}
-
-			res, err := http.Get(url)
-			if err != nil {
-				errs <- i
-				log.Print(err)
-				return
-			}
-
-			defer res.Body.Close()
-			data, err := ioutil.ReadAll(res.Body)
-			if err != nil {
-				errs <- i
-				log.Print(err)
-				return
-			}
-
-			img, _, err := image.Decode(bytes.NewReader(data))
-			if err != nil {
-				errs <- i
-			} else {
-				albums[i].Image = &img
-				success <- i
-			}
-		}(i, &wg)
-	}
+// hasArt reports whether image urls were found for the album
+func (a album) hasArt() bool {
+	return a.ImageURLS.Large != "" && a.ImageURLS.Medium != ""
+}
 
-	go func() {
-		wg.Wait()
-		close(wgDone)
-	}()
-
-	count := 0
-	var errIndexes []int
-	for {
-		select {
-		case <-success:
-			count++
-		case i := <-errs:
-			errIndexes = append(errIndexes, i)
-		case <-wgDone:
-			return errIndexes
+// removeAlbumsWithoutArt drops the albums that have no image urls, keeping the rest in order
+func removeAlbumsWithoutArt(albums []album) []album {
+	var withArt []album
+	for _, a := range albums {
+		if a.hasArt() {
+			withArt = append(withArt, a)
 		}
 	}
+	return withArt
 }
 
 // Find takes a slice and looks for an element in it. If found it will
@@ -361,7 +650,8 @@ func find(slice []int, val int) bool {
 	return false
 }
 
-func sortAlbumsByHsv(albums []album) error {
+// setAlbumColors works out the colour of each album's cover with the colour extractor
+func setAlbumColors(albums []album, extract colorExtractor) {
 
 	var wg sync.WaitGroup
 
@@ -369,35 +659,31 @@ func sortAlbumsByHsv(albums []album) error {
 		wg.Add(1)
 		go func(i int, wg *sync.WaitGroup) {
 			defer wg.Done()
-			albums[i].Color = getAlbumColor(albums[i].Image, albums[i].Title)
+			albums[i].Color = getAlbumColor(albums[i].Image, extract)
 		}(i, &wg)
 	}
 
 	wg.Wait()
-
-	sort.Slice(albums, func(i int, j int) bool {
-		if albums[i].Color.Hue == albums[j].Color.Hue {
-			if albums[i].Color.Sat == albums[j].Color.Sat {
-				return albums[i].Color.Value < albums[j].Color.Value
-			}
-			return albums[i].Color.Sat < albums[j].Color.Sat
-		}
-		return albums[i].Color.Hue < albums[j].Color.Hue
-	})
-	return nil
 }
 
-// getAlbumColor gets the
-func getAlbumColor(i *image.Image, t string) albumColor {
-	color := averageImageColor(*i)
+// getAlbumColor gets the HSV colour and luminance of the colour picked from an album's cover
+func getAlbumColor(i *image.Image, extract colorExtractor) albumColor {
+	color := extract(*i)
 	h, s, v := hsv(color)
 	return albumColor{
-		Hue:   h * 60,
-		Sat:   s,
-		Value: v,
+		Hue:       h * 60,
+		Sat:       s,
+		Value:     v,
+		Luminance: luminance(color),
 	}
 }
 
+// luminance gets the relative luminance of a colour using the Rec. 709 coefficients
+func luminance(c color.Color) float64 {
+	r, g, b := normalize(c)
+	return 0.2126*r + 0.7152*g + 0.0722*b
+}
+
 // hsv converts a go color type to HSV format (Hue, Saturation, Value)
 func hsv(c color.Color) (h, s, v float64) {
 	fR, fG, fB := normalize(c)
@@ -463,63 +749,3 @@ func averageImageColor(i image.Image) color.Color {
 
 	return color.NRGBA{uint8(r / 0x101), uint8(g / 0x101), uint8(b / 0x101), 255}
 }
-
-func rearrangeAlbums(albums []album, x int, y int) {
-
-	matrix := make([][]album, y)
-	for i := 0; i < y; i++ {
-		matrix[i] = make([]album, x)
-	}
-
-	row, col := 0, 0
-	var dir = true
-	l := 0
-
-	for row < y && col < x {
-		matrix[row][col] = albums[l]
-		l++
-
-		var newRow int
-		var newCol int
-
-		if dir {
-			newRow = row + -1
-			newCol = col + 1
-		} else {
-			newRow = row + 1
-			newCol = col + -1
-		}
-
-		if newRow < 0 || newRow == y || newCol < 0 || newCol == x {
-			if dir {
-				if col == x-1 {
-					row++
-				}
-				if col < x-1 {
-					col++
-				}
-			} else {
-				if row == y-1 {
-					col++
-				}
-				if row < y-1 {
-					row++
-				}
-			}
-			dir = !dir
-		} else {
-			row = newRow
-			col = newCol
-		}
-
-	}
-
-	l = 0
-
-	for i := 0; i < y; i++ {
-		for j := 0; j < x; j++ {
-			albums[l] = matrix[i][j]
-			l++
-		}
-	}
-}
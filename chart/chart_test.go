@@ -0,0 +1,56 @@
+package chart
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"testing"
+
+	"github.com/conorbros/las-tools/conf"
+)
+
+func TestExtractQuerySize(t *testing.T) {
+	tests := []struct {
+		x, y  string
+		valid bool
+	}{
+		{"5", "5", true},
+		{"32", "18", true},
+		{"1", "1", true},
+		{"0", "5", false},
+		{"5", "0", false},
+		{"-2", "-2", false},
+		{"51", "1", false},
+		{"40", "40", false},
+	}
+
+	for _, tt := range tests {
+		values := url.Values{"username": {"rj"}, "x": {tt.x}, "y": {tt.y}}
+		_, err := extractQuery(values)
+		if _, isQueryErr := err.(*queryError); err != nil && !isQueryErr {
+			t.Errorf("extractQuery(x=%s, y=%s) = %v; want a queryError", tt.x, tt.y, err)
+		}
+		if (err == nil) != tt.valid {
+			t.Errorf("extractQuery(x=%s, y=%s) = %v; want valid %v", tt.x, tt.y, err, tt.valid)
+		}
+	}
+}
+
+func TestGetLastFmTopAlbumsCapsLimit(t *testing.T) {
+	var limit string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		limit = r.URL.Query().Get("limit")
+		fmt.Fprint(w, `{"topalbums":{"album":[]}}`)
+	}))
+	defer server.Close()
+	conf.Config.LastFm.UserTopAlbumsEndpoint = server.URL + "/?method=user.gettopalbums"
+
+	if _, err := getLastFmTopAlbums("capped", maxChartCells, "7day"); err != nil {
+		t.Fatal(err)
+	}
+	if limit != strconv.Itoa(lastFmMaxLimit) {
+		t.Errorf("limit = %s; want %d", limit, lastFmMaxLimit)
+	}
+}
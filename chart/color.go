@@ -0,0 +1,159 @@
+package chart
+
+import (
+	"image"
+	"image/color"
+	"math"
+	"sort"
+)
+
+// Names of the colour modes accepted by the colormode query parameter
+const (
+	colorModeAverage  = "average"
+	colorModeDominant = "dominant"
+)
+
+// Settings for dominant colour extraction
+const (
+	// dominantSamples is the most pixels sampled along each side of a cover
+	dominantSamples = 32
+	// dominantClusters is the number of colours a cover is reduced to
+	dominantClusters = 5
+	// dominantIterations is the number of k-means iterations run
+	dominantIterations = 10
+	// dominantBaseWeight is how much a colourless cluster counts compared to a fully
+	// saturated cluster of the same size
+	dominantBaseWeight = 0.05
+)
+
+// colorExtractor picks the colour an album is sorted by from its cover
+type colorExtractor func(image.Image) color.Color
+
+var colorModes = map[string]colorExtractor{
+	colorModeAverage:  averageImageColor,
+	colorModeDominant: dominantImageColor,
+}
+
+// rgb is a colour with components in the range [0,1)
+type rgb struct {
+	R, G, B float64
+}
+
+func (c rgb) distance(o rgb) float64 {
+	dr, dg, db := c.R-o.R, c.G-o.G, c.B-o.B
+	return dr*dr + dg*dg + db*db
+}
+
+// chroma is the difference between the largest and smallest components, which is close to
+// zero for black, white and greys
+func (c rgb) chroma() float64 {
+	return math.Max(math.Max(c.R, c.G), c.B) - math.Min(math.Min(c.R, c.G), c.B)
+}
+
+// dominantImageColor finds the dominant colour of a cover by clustering a downsampled copy of
+// it with k-means. The largest cluster wins, but colourless clusters are weighted down so a
+// red logo on a black background comes out red rather than black or a muddy brown.
+func dominantImageColor(i image.Image) color.Color {
+	samples := sampleImage(i)
+	if len(samples) == 0 {
+		return color.NRGBA{0, 0, 0, 255}
+	}
+
+	centroids := initialCentroids(samples)
+	assignments := make([]int, len(samples))
+	counts := make([]int, len(centroids))
+
+	for iter := 0; iter < dominantIterations; iter++ {
+		for k := range counts {
+			counts[k] = 0
+		}
+
+		changed := false
+		for s, c := range samples {
+			nearest := 0
+			for k := 1; k < len(centroids); k++ {
+				if c.distance(centroids[k]) < c.distance(centroids[nearest]) {
+					nearest = k
+				}
+			}
+			if assignments[s] != nearest {
+				assignments[s] = nearest
+				changed = true
+			}
+			counts[nearest]++
+		}
+
+		sums := make([]rgb, len(centroids))
+		for s, c := range samples {
+			k := assignments[s]
+			sums[k].R += c.R
+			sums[k].G += c.G
+			sums[k].B += c.B
+		}
+		for k := range centroids {
+			if counts[k] > 0 {
+				n := float64(counts[k])
+				centroids[k] = rgb{sums[k].R / n, sums[k].G / n, sums[k].B / n}
+			}
+		}
+
+		if !changed && iter > 0 {
+			break
+		}
+	}
+
+	best, bestWeight := 0, -1.0
+	for k, c := range centroids {
+		weight := float64(counts[k]) * (dominantBaseWeight + c.chroma())
+		if weight > bestWeight {
+			best, bestWeight = k, weight
+		}
+	}
+
+	c := centroids[best]
+	return color.NRGBA{uint8(c.R * 255), uint8(c.G * 255), uint8(c.B * 255), 255}
+}
+
+// sampleImage takes an evenly spaced grid of at most dominantSamples x dominantSamples pixels
+func sampleImage(i image.Image) []rgb {
+	bounds := i.Bounds()
+
+	stepX := (bounds.Dx() + dominantSamples - 1) / dominantSamples
+	stepY := (bounds.Dy() + dominantSamples - 1) / dominantSamples
+	if stepX < 1 {
+		stepX = 1
+	}
+	if stepY < 1 {
+		stepY = 1
+	}
+
+	var samples []rgb
+	for y := bounds.Min.Y; y < bounds.Max.Y; y += stepY {
+		for x := bounds.Min.X; x < bounds.Max.X; x += stepX {
+			r, g, b := normalize(i.At(x, y))
+			samples = append(samples, rgb{r, g, b})
+		}
+	}
+	return samples
+}
+
+// initialCentroids spreads the starting centroids evenly through the samples ordered by
+// brightness, so the clustering is deterministic and starts with both dark and light colours
+func initialCentroids(samples []rgb) []rgb {
+	sorted := make([]rgb, len(samples))
+	copy(sorted, samples)
+	sort.Slice(sorted, func(a, b int) bool {
+		return sorted[a].R+sorted[a].G+sorted[a].B < sorted[b].R+sorted[b].G+sorted[b].B
+	})
+
+	k := dominantClusters
+	if len(sorted) < k {
+		k = len(sorted)
+	}
+
+	centroids := make([]rgb, k)
+	for c := range centroids {
+		centroids[c] = sorted[(2*c+1)*len(sorted)/(2*k)]
+	}
+	return centroids
+}
@@ -0,0 +1,70 @@
+package chart
+
+import (
+	"image"
+	"image/color"
+	"image/draw"
+	"math/rand"
+	"testing"
+)
+
+// logoCover makes a black cover with a red square logo in the middle
+func logoCover(size int) image.Image {
+	img := image.NewRGBA(image.Rect(0, 0, size, size))
+	draw.Draw(img, img.Bounds(), &image.Uniform{color.Black}, image.Point{}, draw.Src)
+	logo := image.Rect(size*3/8, size*3/8, size*5/8, size*5/8)
+	draw.Draw(img, logo, &image.Uniform{color.RGBA{220, 20, 30, 255}}, image.Point{}, draw.Src)
+	return img
+}
+
+func TestDominantImageColorPicksLogo(t *testing.T) {
+	h, s, _ := hsv(dominantImageColor(logoCover(largeTileSize)))
+
+	if s < 0.5 || (h > 0.05 && h < 0.95) {
+		t.Errorf("dominant colour has hue %f and saturation %f; want a saturated red", h, s)
+	}
+}
+
+func TestDominantImageColorPlainCover(t *testing.T) {
+	img := image.NewRGBA(image.Rect(0, 0, mediumTileSize, mediumTileSize))
+	draw.Draw(img, img.Bounds(), &image.Uniform{color.RGBA{0, 0, 200, 255}}, image.Point{}, draw.Src)
+
+	r, g, b, _ := dominantImageColor(img).RGBA()
+	if r>>8 != 0 || g>>8 != 0 || b>>8 < 198 || b>>8 > 200 {
+		t.Errorf("dominant colour = %d, %d, %d; want 0, 0, 200", r>>8, g>>8, b>>8)
+	}
+}
+
+// chartCovers makes a 30x30 chart's worth of noisy medium sized covers
+func chartCovers() []album {
+	rnd := rand.New(rand.NewSource(1))
+	albums := make([]album, 30*30)
+	for i := range albums {
+		img := image.NewRGBA(image.Rect(0, 0, mediumTileSize, mediumTileSize))
+		for p := 0; p < len(img.Pix); p += 4 {
+			img.Pix[p] = uint8(rnd.Intn(256))
+			img.Pix[p+1] = uint8(rnd.Intn(256))
+			img.Pix[p+2] = uint8(rnd.Intn(256))
+			img.Pix[p+3] = 255
+		}
+		var cover image.Image = img
+		albums[i].Image = &cover
+	}
+	return albums
+}
+
+func BenchmarkAverageImageColor(b *testing.B) {
+	albums := chartCovers()
+	b.ResetTimer()
+	for n := 0; n < b.N; n++ {
+		setAlbumColors(albums, averageImageColor)
+	}
+}
+
+func BenchmarkDominantImageColor(b *testing.B) {
+	albums := chartCovers()
+	b.ResetTimer()
+	for n := 0; n < b.N; n++ {
+		setAlbumColors(albums, dominantImageColor)
+	}
+}
@@ -0,0 +1,195 @@
+package chart
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"image"
+	// Register the decoders for the cover formats served by Last.fm and Spotify
+	_ "image/jpeg"
+	_ "image/png"
+	"io/ioutil"
+	"log"
+	"net"
+	"net/http"
+	"sync/atomic"
+	"time"
+
+	"github.com/conorbros/las-tools/conf"
+)
+
+// downloadBackoff is the wait before the first retry of a cover download. It doubles after each retry.
+const downloadBackoff = 250 * time.Millisecond
+
+// coverClient is shared by every cover download so connections to the image CDNs are reused
+var coverClient = newCoverClient()
+
+func newCoverClient() *http.Client {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.MaxIdleConnsPerHost = conf.Config.Chart.DownloadWorkers
+
+	return &http.Client{
+		Transport: transport,
+		Timeout:   time.Duration(conf.Config.Chart.DownloadTimeoutSeconds) * time.Second,
+	}
+}
+
+// retryableError marks a download error that is worth trying again
+type retryableError struct {
+	err error
+}
+
+func (e *retryableError) Error() string {
+	return e.err.Error()
+}
+
+// downloadImages downloads the cover of each album with a pool of workers and sets the album's
+// image. Covers in the cover cache are not downloaded again. The indexes of albums whose covers
+// could not be downloaded are returned. Once the context is cancelled no more downloads are
+// started and the remaining albums are returned as failed. If progress isn't nil it is told how
+// many albums have been done after each one.
+func downloadImages(ctx context.Context, albums []album, size string, progress progressFunc) []int {
+	jobs := make(chan int)
+	errs := make(chan int)
+	finished := make(chan bool)
+
+	workers := conf.Config.Chart.DownloadWorkers
+	if workers > len(albums) {
+		workers = len(albums)
+	}
+
+	var completed int32
+	if progress != nil {
+		progress(stageDownloading, 0, len(albums))
+	}
+
+	done := make(chan bool)
+	for w := 0; w < workers; w++ {
+		go func() {
+			for i := range jobs {
+				if !downloadCover(ctx, &albums[i], size) {
+					errs <- i
+				}
+				if progress != nil {
+					progress(stageDownloading, int(atomic.AddInt32(&completed, 1)), len(albums))
+				}
+			}
+			done <- true
+		}()
+	}
+
+	go func() {
+		for i := range albums {
+			jobs <- i
+		}
+		close(jobs)
+
+		for w := 0; w < workers; w++ {
+			<-done
+		}
+		close(finished)
+	}()
+
+	var errIndexes []int
+	for {
+		select {
+		case i := <-errs:
+			errIndexes = append(errIndexes, i)
+		case <-finished:
+			return errIndexes
+		}
+	}
+}
+
+// downloadCover sets the album's image from the cover cache or by downloading it, and reports
+// whether it succeeded
+func downloadCover(ctx context.Context, a *album, size string) bool {
+	var url string
+	if size == "Large" {
+		url = a.ImageURLS.Large
+	} else {
+		url = a.ImageURLS.Medium
+	}
+
+	if url == "" {
+		return false
+	}
+
+	if img, ok := covers.get(url, tileSize(size)); ok {
+		a.Image = &img
+		return true
+	}
+
+	img, err := downloadImage(ctx, url)
+	if err != nil {
+		if ctx.Err() == nil {
+			log.Print(err)
+		}
+		return false
+	}
+
+	// Artist images from Spotify come in various sizes so scale everything to the tile size
+	img = fitSquare(img, tileSize(size))
+	covers.put(url, tileSize(size), img)
+	a.Image = &img
+	return true
+}
+
+// downloadImage downloads and decodes a single image, retrying with exponential backoff
+// when the request times out or the server responds with a 5xx status
+func downloadImage(ctx context.Context, url string) (image.Image, error) {
+	backoff := downloadBackoff
+
+	for attempt := 0; ; attempt++ {
+		img, err := tryDownloadImage(ctx, url)
+		if err == nil {
+			return img, nil
+		}
+
+		if _, ok := err.(*retryableError); !ok || attempt >= conf.Config.Chart.DownloadRetries {
+			return nil, err
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+	}
+}
+
+func tryDownloadImage(ctx context.Context, url string) (image.Image, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	res, err := coverClient.Do(req)
+	if err != nil {
+		if netErr, ok := err.(net.Error); ok && netErr.Timeout() && ctx.Err() == nil {
+			return nil, &retryableError{err}
+		}
+		return nil, err
+	}
+
+	defer res.Body.Close()
+
+	if res.StatusCode >= 500 {
+		return nil, &retryableError{fmt.Errorf("Downloading %s failed with status %d", url, res.StatusCode)}
+	}
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("Downloading %s failed with status %d", url, res.StatusCode)
+	}
+
+	data, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		if netErr, ok := err.(net.Error); ok && netErr.Timeout() && ctx.Err() == nil {
+			return nil, &retryableError{err}
+		}
+		return nil, err
+	}
+
+	img, _, err := image.Decode(bytes.NewReader(data))
+	return img, err
+}
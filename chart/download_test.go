@@ -0,0 +1,72 @@
+package chart
+
+import (
+	"context"
+	"image"
+	"image/png"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+)
+
+// coverServer serves a cover after failing with status the first failures requests to each path
+func coverServer(status int, failures int32) (*httptest.Server, *int32) {
+	var hits int32
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&hits, 1) <= failures {
+			w.WriteHeader(status)
+			return
+		}
+		png.Encode(w, image.NewRGBA(image.Rect(0, 0, largeTileSize, largeTileSize)))
+	})), &hits
+}
+
+func TestDownloadImagesRetriesServerErrors(t *testing.T) {
+	server, hits := coverServer(http.StatusServiceUnavailable, 2)
+	defer server.Close()
+
+	albums := []album{{ImageURLS: albumImagesURL{Large: server.URL}}}
+	if errIndexes := downloadImages(context.Background(), albums, "Large", nil); len(errIndexes) != 0 {
+		t.Fatalf("downloadImages() failed for %v", errIndexes)
+	}
+	if albums[0].Image == nil {
+		t.Error("downloadImages() did not set the image")
+	}
+	if *hits != 3 {
+		t.Errorf("cover was requested %d times; want 3", *hits)
+	}
+}
+
+func TestDownloadImagesDoesNotRetryClientErrors(t *testing.T) {
+	server, hits := coverServer(http.StatusNotFound, 1)
+	defer server.Close()
+
+	albums := []album{{ImageURLS: albumImagesURL{Large: server.URL}}, {}}
+	errIndexes := downloadImages(context.Background(), albums, "Large", nil)
+	if len(errIndexes) != 2 {
+		t.Errorf("downloadImages() failed for %v; want both albums", errIndexes)
+	}
+	if *hits != 1 {
+		t.Errorf("cover was requested %d times; want 1", *hits)
+	}
+}
+
+func TestDownloadImagesCancelled(t *testing.T) {
+	server, hits := coverServer(http.StatusOK, 0)
+	defer server.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	albums := make([]album, 10)
+	for i := range albums {
+		albums[i].ImageURLS.Large = server.URL
+	}
+	if errIndexes := downloadImages(ctx, albums, "Large", nil); len(errIndexes) != len(albums) {
+		t.Errorf("downloadImages() failed for %d albums; want %d", len(errIndexes), len(albums))
+	}
+	if *hits != 0 {
+		t.Errorf("cover was requested %d times after cancelling; want 0", *hits)
+	}
+}
@@ -0,0 +1,72 @@
+package chart
+
+import (
+	"fmt"
+	"image"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"regexp"
+)
+
+// Names of the image formats accepted by the format query parameter
+const (
+	formatJPEG = "jpeg"
+	formatPNG  = "png"
+	formatGIF  = "gif"
+)
+
+// defaultJPEGQuality is the JPEG quality used when none is supplied
+const defaultJPEGQuality = 80
+
+// outputFormat encodes a finished chart into an image file
+type outputFormat struct {
+	ContentType string
+	Extension   string
+	Encode      func(w io.Writer, img image.Image) error
+}
+
+// newOutputFormat gets the output format with the given name. The quality is only used by JPEG.
+func newOutputFormat(name string, quality int) (outputFormat, error) {
+	switch name {
+	case formatJPEG:
+		return outputFormat{
+			ContentType: "image/jpeg",
+			Extension:   "jpg",
+			Encode: func(w io.Writer, img image.Image) error {
+				return jpeg.Encode(w, img, &jpeg.Options{Quality: quality})
+			},
+		}, nil
+	case formatPNG:
+		return outputFormat{
+			ContentType: "image/png",
+			Extension:   "png",
+			Encode:      png.Encode,
+		}, nil
+	case formatGIF:
+		return outputFormat{
+			ContentType: "image/gif",
+			Extension:   "gif",
+			Encode: func(w io.Writer, img image.Image) error {
+				return gif.Encode(w, img, nil)
+			},
+		}, nil
+	}
+	return outputFormat{}, &queryError{"Invalid format. Must be one of jpeg, png or gif"}
+}
+
+// unsafeFilenameChars matches anything that shouldn't appear in a download filename
+var unsafeFilenameChars = regexp.MustCompile(`[^A-Za-z0-9_-]`)
+
+// filename gets the download filename for the chart, such as username-5x5-overall.png
+func (q chartQuery) filename() string {
+	span := q.Period
+	if q.isDateRange() {
+		// to is exclusive, so step back a day to show the date the user asked for
+		span = q.From.Format(dateLayout) + "-to-" + q.To.AddDate(0, 0, -1).Format(dateLayout)
+	}
+
+	username := unsafeFilenameChars.ReplaceAllString(q.Username, "_")
+	return fmt.Sprintf("%s-%dx%d-%s.%s", username, q.X, q.Y, span, q.Format.Extension)
+}
@@ -0,0 +1,67 @@
+package chart
+
+import (
+	"bytes"
+	"image"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestWriteChartFormats(t *testing.T) {
+	tests := []struct {
+		format      string
+		contentType string
+		filename    string
+		decodedAs   string
+	}{
+		{formatJPEG, "image/jpeg", "rj-5x5-overall.jpg", "jpeg"},
+		{formatPNG, "image/png", "rj-5x5-overall.png", "png"},
+		{formatGIF, "image/gif", "rj-5x5-overall.gif", "gif"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.format, func(t *testing.T) {
+			f, err := newOutputFormat(tt.format, defaultJPEGQuality)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var buf bytes.Buffer
+			if err := f.Encode(&buf, image.NewRGBA(image.Rect(0, 0, 10, 10))); err != nil {
+				t.Fatal(err)
+			}
+			if _, name, err := image.DecodeConfig(bytes.NewReader(buf.Bytes())); err != nil || name != tt.decodedAs {
+				t.Errorf("encoded chart decodes as %q, %v; want %q", name, err, tt.decodedAs)
+			}
+
+			w := httptest.NewRecorder()
+			writeChart(w, chartQuery{Username: "rj", X: 5, Y: 5, Period: "overall", Format: f}, buf.Bytes())
+			if got := w.Header().Get("Content-Type"); got != tt.contentType {
+				t.Errorf("Content-Type = %q; want %q", got, tt.contentType)
+			}
+			if got, want := w.Header().Get("Content-Disposition"), `inline; filename="`+tt.filename+`"`; got != want {
+				t.Errorf("Content-Disposition = %q; want %q", got, want)
+			}
+		})
+	}
+
+	if _, err := newOutputFormat("bmp", 0); err == nil {
+		t.Error("newOutputFormat(\"bmp\") err = nil; want an invalid format error")
+	}
+}
+
+func TestFilename(t *testing.T) {
+	f, _ := newOutputFormat(formatPNG, 0)
+	q := chartQuery{
+		Username: "r/j",
+		X:        3,
+		Y:        4,
+		From:     time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC),
+		To:       time.Date(2020, 2, 1, 0, 0, 0, 0, time.UTC),
+		Format:   f,
+	}
+	if got, want := q.filename(), "r_j-3x4-2020-01-01-to-2020-01-31.png"; got != want {
+		t.Errorf("filename() = %q; want %q", got, want)
+	}
+}
@@ -0,0 +1,70 @@
+package chart
+
+import (
+	"image"
+	"image/color"
+)
+
+// Tile sizes in pixels of the Last.fm "large" and "medium" album images
+const (
+	largeTileSize  = 174
+	mediumTileSize = 64
+)
+
+// tileSize returns the width and height in pixels of a chart tile for the image size
+func tileSize(size string) int {
+	if size == "Large" {
+		return largeTileSize
+	}
+	return mediumTileSize
+}
+
+// fitSquare crops an image to a centred square and scales it to size x size pixels by
+// averaging the source pixels covered by each destination pixel. Images that are already
+// the right size are returned unchanged.
+func fitSquare(img image.Image, size int) image.Image {
+	bounds := img.Bounds()
+	if bounds.Dx() == size && bounds.Dy() == size {
+		return img
+	}
+
+	side := bounds.Dx()
+	if bounds.Dy() < side {
+		side = bounds.Dy()
+	}
+	if side == 0 {
+		return image.NewRGBA(image.Rect(0, 0, size, size))
+	}
+	offX := bounds.Min.X + (bounds.Dx()-side)/2
+	offY := bounds.Min.Y + (bounds.Dy()-side)/2
+
+	dst := image.NewRGBA(image.Rect(0, 0, size, size))
+	for y := 0; y < size; y++ {
+		y0 := offY + y*side/size
+		y1 := offY + (y+1)*side/size
+		if y1 == y0 {
+			y1 = y0 + 1
+		}
+		for x := 0; x < size; x++ {
+			x0 := offX + x*side/size
+			x1 := offX + (x+1)*side/size
+			if x1 == x0 {
+				x1 = x0 + 1
+			}
+
+			var r, g, b, a, n uint64
+			for sy := y0; sy < y1; sy++ {
+				for sx := x0; sx < x1; sx++ {
+					pr, pg, pb, pa := img.At(sx, sy).RGBA()
+					r += uint64(pr)
+					g += uint64(pg)
+					b += uint64(pb)
+					a += uint64(pa)
+					n++
+				}
+			}
+			dst.SetRGBA64(x, y, color.RGBA64{uint16(r / n), uint16(g / n), uint16(b / n), uint16(a / n)})
+		}
+	}
+	return dst
+}
@@ -0,0 +1,314 @@
+package chart
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/conorbros/las-tools/conf"
+)
+
+// Statuses of a chart job once it has stopped running
+const (
+	stageDone   = "done"
+	stageFailed = "failed"
+)
+
+// jobsPath is the path chart jobs are served under
+const jobsPath = "/api/charts/"
+
+// jobs holds the chart jobs that haven't expired yet
+var jobs = newJobStore(time.Duration(conf.Config.Chart.JobTTLMinutes)*time.Minute, conf.Config.Chart.MaxJobs, conf.Config.Chart.MaxRunningJobs)
+
+// errTooManyJobs is returned when a job can't be started because the store is full
+var errTooManyJobs = errors.New("too many chart jobs")
+
+// jobRetryAfter is how long clients are asked to wait before trying again when the store is full
+const jobRetryAfter = time.Minute
+
+// job is a chart being generated in the background
+type job struct {
+	mu sync.Mutex
+
+	id      string
+	query   chartQuery
+	expires time.Time
+	cancel  context.CancelFunc
+
+	stage string
+	done  int
+	total int
+
+	chart []byte
+	err   error
+}
+
+// jobStatus is the JSON sent for the status of a job
+type jobStatus struct {
+	ID       string `json:"id"`
+	Stage    string `json:"stage"`
+	Done     int    `json:"done,omitempty"`
+	Total    int    `json:"total,omitempty"`
+	Error    string `json:"error,omitempty"`
+	ImageURL string `json:"imageUrl,omitempty"`
+	Expires  string `json:"expires"`
+}
+
+func (j *job) setProgress(stage string, done, total int) {
+	j.mu.Lock()
+	j.stage, j.done, j.total = stage, done, total
+	j.mu.Unlock()
+}
+
+// run generates the chart and stores the result on the job
+func (j *job) run(ctx context.Context) {
+	chart, err := generateChart(ctx, j.query, j.setProgress)
+
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	j.chart, j.err = chart, err
+	j.done, j.total = 0, 0
+	if err != nil {
+		j.stage = stageFailed
+	} else {
+		j.stage = stageDone
+	}
+}
+
+func (j *job) status() jobStatus {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	s := jobStatus{
+		ID:      j.id,
+		Stage:   j.stage,
+		Done:    j.done,
+		Total:   j.total,
+		Expires: j.expires.UTC().Format(time.RFC3339),
+	}
+	switch j.stage {
+	case stageDone:
+		s.ImageURL = jobsPath + j.id + "/image"
+	case stageFailed:
+		s.Error = errorMessage(j.err)
+	}
+	return s
+}
+
+// errorMessage gets the message shown to the user for a chart error
+func errorMessage(err error) string {
+	if cErr, ok := err.(*chartError); ok {
+		return cErr.msg
+	}
+	return "There was an error generating the chart. Try again or contact me."
+}
+
+// running reports whether the job's chart is still being generated
+func (j *job) running() bool {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	return j.stage != stageDone && j.stage != stageFailed
+}
+
+// jobStore keeps chart jobs until they expire. Each job can download thousands of covers and
+// keeps its chart in memory, so the number of jobs kept and running at once is limited.
+type jobStore struct {
+	mu         sync.Mutex
+	ttl        time.Duration
+	maxJobs    int
+	maxRunning int
+	jobs       map[string]*job
+}
+
+func newJobStore(ttl time.Duration, maxJobs, maxRunning int) *jobStore {
+	s := &jobStore{
+		ttl:        ttl,
+		maxJobs:    maxJobs,
+		maxRunning: maxRunning,
+		jobs:       make(map[string]*job),
+	}
+	go s.expireEvery(time.Minute)
+	return s
+}
+
+// start creates a job for the query and starts generating its chart. errTooManyJobs is returned
+// if the store already has maxJobs jobs or maxRunning jobs are still running.
+func (s *jobStore) start(q chartQuery) (*job, error) {
+	id, err := newJobID()
+	if err != nil {
+		return nil, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	if s.full(now) {
+		return nil, errTooManyJobs
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), s.ttl)
+	j := &job{
+		id:      id,
+		query:   q,
+		expires: now.Add(s.ttl),
+		cancel:  cancel,
+		stage:   stageFetching,
+	}
+	s.jobs[id] = j
+
+	go j.run(ctx)
+	return j, nil
+}
+
+// full reports whether another job can't be started because the store has maxJobs jobs that
+// haven't expired or maxRunning jobs are running. s.mu must be held.
+func (s *jobStore) full(now time.Time) bool {
+	kept, running := 0, 0
+	for _, j := range s.jobs {
+		if now.After(j.expires) {
+			continue
+		}
+		kept++
+		if j.running() {
+			running++
+		}
+	}
+	return kept >= s.maxJobs || running >= s.maxRunning
+}
+
+// get gets the job with the id, if it exists and hasn't expired
+func (s *jobStore) get(id string) (*job, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	j, ok := s.jobs[id]
+	if !ok || time.Now().After(j.expires) {
+		return nil, false
+	}
+	return j, true
+}
+
+// expire removes expired jobs, stopping any that are still running
+func (s *jobStore) expire() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	for id, j := range s.jobs {
+		if now.After(j.expires) {
+			j.cancel()
+			delete(s.jobs, id)
+		}
+	}
+}
+
+func (s *jobStore) expireEvery(interval time.Duration) {
+	for range time.Tick(interval) {
+		s.expire()
+	}
+}
+
+func newJobID() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// CreateJobHandler starts generating a chart in the background and returns the job's status.
+// The chart options are the same as for GenerateChartHandler, sent as form values.
+func CreateJobHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, "Bad request. Try reloading the page.", http.StatusBadRequest)
+		return
+	}
+
+	q, err := extractQuery(r.Form)
+	if qErr, ok := err.(*queryError); ok {
+		http.Error(w, qErr.Error(), http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Bad request. Try reloading the page.", http.StatusBadRequest)
+		return
+	}
+
+	j, err := jobs.start(q)
+	if err == errTooManyJobs {
+		w.Header().Set("Retry-After", strconv.Itoa(int(jobRetryAfter.Seconds())))
+		http.Error(w, "Too many charts are being generated right now. Try again in a minute.", http.StatusServiceUnavailable)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Could not start generating the chart. Try again or contact me.", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Location", jobsPath+j.id)
+	writeJobStatus(w, http.StatusAccepted, j.status())
+}
+
+// JobHandler returns the status of a chart job at /api/charts/{id} and its chart at
+// /api/charts/{id}/image once it is done
+func JobHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	parts := strings.Split(strings.TrimPrefix(r.URL.Path, jobsPath), "/")
+	if len(parts) > 2 || (len(parts) == 2 && parts[1] != "image") {
+		http.NotFound(w, r)
+		return
+	}
+
+	j, ok := jobs.get(parts[0])
+	if !ok {
+		http.Error(w, "This chart doesn't exist or has expired. Try generating it again.", http.StatusNotFound)
+		return
+	}
+
+	if len(parts) == 1 {
+		writeJobStatus(w, http.StatusOK, j.status())
+		return
+	}
+
+	j.mu.Lock()
+	stage, chart, err := j.stage, j.chart, j.err
+	j.mu.Unlock()
+
+	switch stage {
+	case stageDone:
+		writeChart(w, j.query, chart)
+	case stageFailed:
+		writeChartError(w, err)
+	default:
+		http.Error(w, "The chart isn't ready yet.", http.StatusConflict)
+	}
+}
+
+func writeJobStatus(w http.ResponseWriter, status int, s jobStatus) {
+	jsonValue, err := json.Marshal(s)
+	if err != nil {
+		http.Error(w, "Could not get the chart status", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-type", "application/json")
+	w.WriteHeader(status)
+	w.Write(jsonValue)
+}
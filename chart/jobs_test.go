@@ -0,0 +1,84 @@
+package chart
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestJobStoreExpires(t *testing.T) {
+	s := &jobStore{ttl: time.Hour, jobs: make(map[string]*job)}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	s.jobs["old"] = &job{id: "old", expires: time.Now().Add(-time.Second), cancel: cancel}
+	s.jobs["new"] = &job{id: "new", expires: time.Now().Add(time.Hour), cancel: func() {}}
+
+	if _, ok := s.get("old"); ok {
+		t.Error("expired job was returned")
+	}
+	s.expire()
+	if ctx.Err() == nil {
+		t.Error("expired job was not cancelled")
+	}
+	if _, ok := s.jobs["old"]; ok {
+		t.Error("expired job was not removed")
+	}
+	if _, ok := s.get("new"); !ok {
+		t.Error("job was removed before it expired")
+	}
+}
+
+func TestJobHandler(t *testing.T) {
+	j := &job{id: "pending", expires: time.Now().Add(time.Hour), cancel: func() {}, stage: stageDownloading, done: 3, total: 9}
+	jobs.mu.Lock()
+	jobs.jobs[j.id] = j
+	jobs.mu.Unlock()
+
+	tests := []struct {
+		path   string
+		status int
+	}{
+		{jobsPath + "pending", http.StatusOK},
+		{jobsPath + "pending/image", http.StatusConflict},
+		{jobsPath + "pending/other", http.StatusNotFound},
+		{jobsPath + "missing", http.StatusNotFound},
+	}
+	for _, test := range tests {
+		w := httptest.NewRecorder()
+		JobHandler(w, httptest.NewRequest("GET", test.path, nil))
+		if w.Code != test.status {
+			t.Errorf("GET %s status = %d; want %d", test.path, w.Code, test.status)
+		}
+	}
+}
+
+func TestJobStoreLimitsJobs(t *testing.T) {
+	s := &jobStore{ttl: time.Hour, maxJobs: 3, maxRunning: 1, jobs: make(map[string]*job)}
+	s.jobs["running"] = &job{id: "running", expires: time.Now().Add(time.Hour), cancel: func() {}, stage: stageDownloading}
+
+	if !s.full(time.Now()) {
+		t.Error("a job could start while maxRunning jobs are running")
+	}
+	if _, err := s.start(chartQuery{}); err != errTooManyJobs {
+		t.Errorf("start with a job running: err = %v; want errTooManyJobs", err)
+	}
+
+	s.jobs["running"].stage = stageDone
+	if s.full(time.Now()) {
+		t.Error("a job couldn't start once the running job finished")
+	}
+
+	s.jobs["failed"] = &job{id: "failed", expires: time.Now().Add(time.Hour), cancel: func() {}, stage: stageFailed}
+	s.jobs["expiring"] = &job{id: "expiring", expires: time.Now().Add(time.Hour), cancel: func() {}, stage: stageDone}
+	if !s.full(time.Now()) {
+		t.Error("a job could start while maxJobs jobs are kept")
+	}
+
+	// Expired jobs don't count towards the limit even before they are removed
+	s.jobs["expiring"].expires = time.Now().Add(-time.Second)
+	if s.full(time.Now()) {
+		t.Error("an expired job counted towards the limit")
+	}
+}
@@ -0,0 +1,266 @@
+package chart
+
+import (
+	"math"
+	"sort"
+)
+
+// Names of the layouts accepted by the layout query parameter
+const (
+	layoutDiagonal = "diagonal"
+	layoutRows     = "rows"
+	layoutSpiral   = "spiral"
+	layoutHilbert  = "hilbert"
+	layoutRadial   = "radial"
+)
+
+// layout decides where each album in the ordered sequence is placed on an x by y grid.
+// The returned slice holds the row-major cell index for each position in the sequence.
+type layout func(x, y int) []int
+
+var layouts = map[string]layout{
+	layoutDiagonal: diagonalLayout,
+	layoutRows:     rowsLayout,
+	layoutSpiral:   spiralLayout,
+	layoutHilbert:  hilbertLayout,
+	layoutRadial:   radialLayout,
+}
+
+// arrangeAlbums moves the first x*y albums into the cells chosen by the layout
+func arrangeAlbums(albums []album, x, y int, l layout) {
+	arranged := make([]album, x*y)
+	for i, cell := range l(x, y) {
+		arranged[cell] = albums[i]
+	}
+	copy(albums, arranged)
+}
+
+// rowsLayout fills the grid left to right, top to bottom
+func rowsLayout(x, y int) []int {
+	cells := make([]int, x*y)
+	for i := range cells {
+		cells[i] = i
+	}
+	return cells
+}
+
+// diagonalLayout fills the grid along its anti-diagonals, zigzagging from the top left
+// to the bottom right corner
+func diagonalLayout(x, y int) []int {
+	var cells []int
+
+	row, col := 0, 0
+	var dir = true
+
+	for row < y && col < x {
+		cells = append(cells, row*x+col)
+
+		var newRow int
+		var newCol int
+
+		if dir {
+			newRow = row + -1
+			newCol = col + 1
+		} else {
+			newRow = row + 1
+			newCol = col + -1
+		}
+
+		if newRow < 0 || newRow == y || newCol < 0 || newCol == x {
+			if dir {
+				if col == x-1 {
+					row++
+				}
+				if col < x-1 {
+					col++
+				}
+			} else {
+				if row == y-1 {
+					col++
+				}
+				if row < y-1 {
+					row++
+				}
+			}
+			dir = !dir
+		} else {
+			row = newRow
+			col = newCol
+		}
+	}
+
+	return cells
+}
+
+// spiralLayout fills the grid in a clockwise spiral out from the centre. On grids that
+// aren't square the spiral skips the cells that fall outside the grid. The spiral covers
+// the grid within max(x, y)^2 steps so it stops there even if the grid has no cells.
+func spiralLayout(x, y int) []int {
+	size := x * y
+	if x < 1 || y < 1 {
+		size = 0
+	}
+	cells := make([]int, 0, size)
+
+	maxSteps := x
+	if y > x {
+		maxSteps = y
+	}
+	maxSteps *= maxSteps
+
+	row, col := (y-1)/2, (x-1)/2
+	// right, down, left, up
+	dirs := [4][2]int{{0, 1}, {1, 0}, {0, -1}, {-1, 0}}
+
+	add := func() {
+		if row >= 0 && row < y && col >= 0 && col < x {
+			cells = append(cells, row*x+col)
+		}
+	}
+
+	add()
+	walked := 0
+	for steps, d := 1, 0; len(cells) < size && walked < maxSteps; d++ {
+		for i := 0; i < steps && walked < maxSteps; i++ {
+			walked++
+			row += dirs[d%4][0]
+			col += dirs[d%4][1]
+			add()
+		}
+		// The spiral's arms grow by one after every second turn
+		if d%2 == 1 {
+			steps++
+		}
+	}
+
+	return cells
+}
+
+// hilbertLayout fills the grid along a generalised Hilbert curve, which works for any
+// rectangle and keeps albums that are next to each other in the sequence close together
+// on the chart.
+func hilbertLayout(x, y int) []int {
+	cells := make([]int, 0, x*y)
+	visit := func(col, row int) {
+		cells = append(cells, row*x+col)
+	}
+
+	if x >= y {
+		gilbert(0, 0, x, 0, 0, y, visit)
+	} else {
+		gilbert(0, 0, 0, y, x, 0, visit)
+	}
+	return cells
+}
+
+// gilbert walks the rectangle starting at (px, py) with major axis (ax, ay) and minor
+// axis (bx, by), calling visit for each cell. See https://github.com/jakubcerveny/gilbert
+func gilbert(px, py, ax, ay, bx, by int, visit func(col, row int)) {
+	w := abs(ax + ay)
+	h := abs(bx + by)
+
+	dax, day := sign(ax), sign(ay)
+	dbx, dby := sign(bx), sign(by)
+
+	if h == 1 {
+		for i := 0; i < w; i++ {
+			visit(px, py)
+			px, py = px+dax, py+day
+		}
+		return
+	}
+	if w == 1 {
+		for i := 0; i < h; i++ {
+			visit(px, py)
+			px, py = px+dbx, py+dby
+		}
+		return
+	}
+
+	ax2, ay2 := floorHalf(ax), floorHalf(ay)
+	bx2, by2 := floorHalf(bx), floorHalf(by)
+
+	w2 := abs(ax2 + ay2)
+	h2 := abs(bx2 + by2)
+
+	if 2*w > 3*h {
+		// Long case, split in two along the major axis
+		if w2%2 != 0 && w > 2 {
+			ax2, ay2 = ax2+dax, ay2+day
+		}
+		gilbert(px, py, ax2, ay2, bx, by, visit)
+		gilbert(px+ax2, py+ay2, ax-ax2, ay-ay2, bx, by, visit)
+		return
+	}
+
+	// Standard case, one step up, one long step across and one step down
+	if h2%2 != 0 && h > 2 {
+		bx2, by2 = bx2+dbx, by2+dby
+	}
+	gilbert(px, py, bx2, by2, ax2, ay2, visit)
+	gilbert(px+bx2, py+by2, ax, ay, bx-bx2, by-by2, visit)
+	gilbert(px+(ax-dax)+(bx2-dbx), py+(ay-day)+(by2-dby), -bx2, -by2, -(ax - ax2), -(ay - ay2), visit)
+}
+
+// radialLayout fills the grid in rings out from the centre, clockwise from twelve o'clock
+// within each ring, so the start of the sequence (the most played albums when ordered by
+// rank or playcount) sits in the middle of the chart
+func radialLayout(x, y int) []int {
+	cx, cy := float64(x-1)/2, float64(y-1)/2
+
+	type cell struct {
+		index int
+		dist  float64
+		angle float64
+	}
+
+	cells := make([]cell, 0, x*y)
+	for row := 0; row < y; row++ {
+		for col := 0; col < x; col++ {
+			dx, dy := float64(col)-cx, float64(row)-cy
+			angle := math.Atan2(dx, -dy)
+			if angle < 0 {
+				angle += 2 * math.Pi
+			}
+			cells = append(cells, cell{index: row*x + col, dist: dx*dx + dy*dy, angle: angle})
+		}
+	}
+
+	sort.SliceStable(cells, func(i, j int) bool {
+		if cells[i].dist == cells[j].dist {
+			return cells[i].angle < cells[j].angle
+		}
+		return cells[i].dist < cells[j].dist
+	})
+
+	indexes := make([]int, len(cells))
+	for i, c := range cells {
+		indexes[i] = c.index
+	}
+	return indexes
+}
+
+func abs(n int) int {
+	if n < 0 {
+		return -n
+	}
+	return n
+}
+
+func sign(n int) int {
+	switch {
+	case n < 0:
+		return -1
+	case n > 0:
+		return 1
+	}
+	return 0
+}
+
+// floorHalf divides n by two rounding towards negative infinity
+func floorHalf(n int) int {
+	if n < 0 {
+		return -((-n + 1) / 2)
+	}
+	return n / 2
+}
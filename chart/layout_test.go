@@ -0,0 +1,93 @@
+package chart
+
+import (
+	"reflect"
+	"strconv"
+	"testing"
+)
+
+func TestLayouts(t *testing.T) {
+	tests := []struct {
+		layout string
+		x, y   int
+		want   []int
+	}{
+		{layoutRows, 3, 2, []int{0, 1, 2, 3, 4, 5}},
+		{layoutDiagonal, 3, 3, []int{0, 1, 3, 6, 4, 2, 5, 7, 8}},
+		{layoutDiagonal, 3, 2, []int{0, 1, 3, 4, 2, 5}},
+		{layoutSpiral, 3, 3, []int{4, 5, 8, 7, 6, 3, 0, 1, 2}},
+		{layoutSpiral, 4, 2, []int{1, 2, 6, 5, 4, 0, 3, 7}},
+		{layoutHilbert, 2, 2, []int{0, 2, 3, 1}},
+		{layoutHilbert, 4, 4, []int{0, 1, 5, 4, 8, 12, 13, 9, 10, 14, 15, 11, 7, 6, 2, 3}},
+		{layoutRadial, 3, 3, []int{4, 1, 5, 7, 3, 2, 8, 6, 0}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.layout+"_"+strconv.Itoa(tt.x)+"x"+strconv.Itoa(tt.y), func(t *testing.T) {
+			if got := layouts[tt.layout](tt.x, tt.y); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("%s(%d, %d) = %v; want %v", tt.layout, tt.x, tt.y, got, tt.want)
+			}
+		})
+	}
+}
+
+// TestLayoutsFillGrid checks every layout places exactly one album in every cell
+func TestLayoutsFillGrid(t *testing.T) {
+	sizes := [][2]int{{1, 1}, {5, 5}, {10, 10}, {16, 9}, {9, 16}, {32, 18}, {7, 3}}
+
+	for name, l := range layouts {
+		for _, size := range sizes {
+			x, y := size[0], size[1]
+			cells := l(x, y)
+			if len(cells) != x*y {
+				t.Errorf("%s(%d, %d) placed %d albums; want %d", name, x, y, len(cells), x*y)
+				continue
+			}
+
+			seen := make([]bool, x*y)
+			for _, c := range cells {
+				if c < 0 || c >= x*y || seen[c] {
+					t.Errorf("%s(%d, %d) placed an album in cell %d twice or out of bounds", name, x, y, c)
+					break
+				}
+				seen[c] = true
+			}
+		}
+	}
+}
+
+// TestSpiralLayoutDegenerateSizes checks the spiral stops on grids without any cells
+func TestSpiralLayoutDegenerateSizes(t *testing.T) {
+	for _, size := range [][2]int{{0, 0}, {-2, -2}, {0, 5}, {5, 0}, {-2, 3}, {3, -2}} {
+		x, y := size[0], size[1]
+		if cells := spiralLayout(x, y); len(cells) != 0 {
+			t.Errorf("spiralLayout(%d, %d) = %v; want no cells", x, y, cells)
+		}
+	}
+}
+
+// TestHilbertLayoutIsContinuous checks neighbours in the sequence are neighbours on even sized grids
+func TestHilbertLayoutIsContinuous(t *testing.T) {
+	for _, size := range [][2]int{{4, 4}, {8, 8}, {16, 8}, {32, 18}} {
+		x, y := size[0], size[1]
+		cells := hilbertLayout(x, y)
+		for i := 1; i < len(cells); i++ {
+			dr := abs(cells[i]/x - cells[i-1]/x)
+			dc := abs(cells[i]%x - cells[i-1]%x)
+			if dr+dc != 1 {
+				t.Errorf("hilbertLayout(%d, %d) jumps from cell %d to %d", x, y, cells[i-1], cells[i])
+				break
+			}
+		}
+	}
+}
+
+func TestArrangeAlbums(t *testing.T) {
+	albums := []album{{Title: "a"}, {Title: "b"}, {Title: "c"}, {Title: "d"}}
+
+	arrangeAlbums(albums, 2, 2, hilbertLayout)
+
+	if got, want := titles(albums), []string{"a", "d", "b", "c"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("arrangeAlbums() = %v; want %v", got, want)
+	}
+}
@@ -0,0 +1,135 @@
+package chart
+
+import (
+	"math/rand"
+	"sort"
+	"strings"
+)
+
+// Names of the orders accepted by the order query parameter
+const (
+	orderRank       = "rank"
+	orderPlaycount  = "playcount"
+	orderHue        = "hue"
+	orderLuminance  = "luminance"
+	orderSaturation = "saturation"
+	orderArtist     = "artist"
+	orderRandom     = "random"
+)
+
+// orderStrategy decides the order albums appear in on the chart
+type orderStrategy interface {
+	// usesColor reports whether the strategy needs the colour of each album to be set before sorting
+	usesColor() bool
+	// sort orders the albums in place
+	sort(albums []album)
+}
+
+// newOrderStrategy gets the order strategy with the given name. The seed is only used by the random order.
+func newOrderStrategy(name string, seed int64) (orderStrategy, error) {
+	switch name {
+	case orderRank:
+		return rankOrder{}, nil
+	case orderPlaycount:
+		return playcountOrder{}, nil
+	case orderHue:
+		return hueOrder{}, nil
+	case orderLuminance:
+		return luminanceOrder{}, nil
+	case orderSaturation:
+		return saturationOrder{}, nil
+	case orderArtist:
+		return artistOrder{}, nil
+	case orderRandom:
+		return randomOrder{seed: seed}, nil
+	}
+	return nil, &queryError{"Invalid order. Must be one of rank, playcount, hue, luminance, saturation, artist or random"}
+}
+
+// rankOrder keeps the albums in the order Last.fm returned them
+type rankOrder struct{}
+
+func (rankOrder) usesColor() bool { return false }
+
+func (rankOrder) sort(albums []album) {}
+
+// playcountOrder orders albums from most to least played, keeping Last.fm's order for ties
+type playcountOrder struct{}
+
+func (playcountOrder) usesColor() bool { return false }
+
+func (playcountOrder) sort(albums []album) {
+	sort.SliceStable(albums, func(i, j int) bool {
+		return albums[i].Playcount > albums[j].Playcount
+	})
+}
+
+// hueOrder orders albums by hue, then saturation, then value to make a rainbow
+type hueOrder struct{}
+
+func (hueOrder) usesColor() bool { return true }
+
+func (hueOrder) sort(albums []album) {
+	sort.Slice(albums, func(i int, j int) bool {
+		if albums[i].Color.Hue == albums[j].Color.Hue {
+			if albums[i].Color.Sat == albums[j].Color.Sat {
+				return albums[i].Color.Value < albums[j].Color.Value
+			}
+			return albums[i].Color.Sat < albums[j].Color.Sat
+		}
+		return albums[i].Color.Hue < albums[j].Color.Hue
+	})
+}
+
+// luminanceOrder orders albums from darkest to lightest
+type luminanceOrder struct{}
+
+func (luminanceOrder) usesColor() bool { return true }
+
+func (luminanceOrder) sort(albums []album) {
+	sort.SliceStable(albums, func(i, j int) bool {
+		return albums[i].Color.Luminance < albums[j].Color.Luminance
+	})
+}
+
+// saturationOrder orders albums from greyest to most colourful, then by hue
+type saturationOrder struct{}
+
+func (saturationOrder) usesColor() bool { return true }
+
+func (saturationOrder) sort(albums []album) {
+	sort.SliceStable(albums, func(i, j int) bool {
+		if albums[i].Color.Sat == albums[j].Color.Sat {
+			return albums[i].Color.Hue < albums[j].Color.Hue
+		}
+		return albums[i].Color.Sat < albums[j].Color.Sat
+	})
+}
+
+// artistOrder orders albums alphabetically by artist, then title
+type artistOrder struct{}
+
+func (artistOrder) usesColor() bool { return false }
+
+func (artistOrder) sort(albums []album) {
+	sort.SliceStable(albums, func(i, j int) bool {
+		a, b := strings.ToLower(albums[i].Artist), strings.ToLower(albums[j].Artist)
+		if a == b {
+			return strings.ToLower(albums[i].Title) < strings.ToLower(albums[j].Title)
+		}
+		return a < b
+	})
+}
+
+// randomOrder shuffles the albums. The same seed always gives the same chart.
+type randomOrder struct {
+	seed int64
+}
+
+func (randomOrder) usesColor() bool { return false }
+
+func (o randomOrder) sort(albums []album) {
+	rand.New(rand.NewSource(o.seed)).Shuffle(len(albums), func(i, j int) {
+		albums[i], albums[j] = albums[j], albums[i]
+	})
+}
@@ -0,0 +1,81 @@
+package chart
+
+import (
+	"reflect"
+	"testing"
+)
+
+// syntheticAlbums makes a small chart's worth of albums in Last.fm rank order
+func syntheticAlbums() []album {
+	return []album{
+		{Artist: "Radiohead", Title: "OK Computer", Playcount: 50, Color: albumColor{Hue: 40, Sat: 0.2, Value: 0.9, Luminance: 0.8}},
+		{Artist: "boards of Canada", Title: "Geogaddi", Playcount: 80, Color: albumColor{Hue: 10, Sat: 0.9, Value: 0.5, Luminance: 0.3}},
+		{Artist: "Aphex Twin", Title: "Syro", Playcount: 80, Color: albumColor{Hue: 30, Sat: 0.5, Value: 0.1, Luminance: 0.05}},
+		{Artist: "Radiohead", Title: "Kid A", Playcount: 20, Color: albumColor{Hue: 10, Sat: 0.4, Value: 0.7, Luminance: 0.5}},
+	}
+}
+
+func titles(albums []album) []string {
+	var t []string
+	for _, a := range albums {
+		t = append(t, a.Title)
+	}
+	return t
+}
+
+func TestOrderStrategies(t *testing.T) {
+	tests := []struct {
+		order string
+		want  []string
+	}{
+		{orderRank, []string{"OK Computer", "Geogaddi", "Syro", "Kid A"}},
+		{orderPlaycount, []string{"Geogaddi", "Syro", "OK Computer", "Kid A"}},
+		{orderHue, []string{"Kid A", "Geogaddi", "Syro", "OK Computer"}},
+		{orderLuminance, []string{"Syro", "Geogaddi", "Kid A", "OK Computer"}},
+		{orderSaturation, []string{"OK Computer", "Kid A", "Syro", "Geogaddi"}},
+		{orderArtist, []string{"Syro", "Geogaddi", "Kid A", "OK Computer"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.order, func(t *testing.T) {
+			order, err := newOrderStrategy(tt.order, 0)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			albums := syntheticAlbums()
+			order.sort(albums)
+
+			if got := titles(albums); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("sort() = %v; want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRandomOrderIsSeeded(t *testing.T) {
+	first, _ := newOrderStrategy(orderRandom, 42)
+	second, _ := newOrderStrategy(orderRandom, 42)
+
+	a, b := syntheticAlbums(), syntheticAlbums()
+	first.sort(a)
+	second.sort(b)
+
+	if !reflect.DeepEqual(titles(a), titles(b)) {
+		t.Errorf("random orders with the same seed differ: %v and %v", titles(a), titles(b))
+	}
+
+	seen := make(map[string]bool)
+	for _, title := range titles(a) {
+		seen[title] = true
+	}
+	if len(seen) != len(a) {
+		t.Errorf("random order lost albums: %v", titles(a))
+	}
+}
+
+func TestNewOrderStrategyInvalid(t *testing.T) {
+	if _, err := newOrderStrategy("colourful", 0); err == nil {
+		t.Error("newOrderStrategy(colourful) returned no error")
+	}
+}
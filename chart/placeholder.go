@@ -0,0 +1,117 @@
+package chart
+
+import (
+	"hash/fnv"
+	"image"
+	"image/color"
+	"image/draw"
+	"math"
+	"strings"
+
+	"golang.org/x/image/font"
+)
+
+// Saturation and value of placeholder backgrounds. Only the hue changes between artists so
+// placeholders look like a set and white text is always readable on them.
+const (
+	placeholderSat   = 0.45
+	placeholderValue = 0.45
+)
+
+// placeholderImage generates a cover for an album without art. The background colour is
+// derived from the artist's name so an artist's placeholders always match, and the artist
+// and title are printed on top.
+func placeholderImage(a album, size int) (image.Image, error) {
+	img := image.NewRGBA(image.Rect(0, 0, size, size))
+	draw.Draw(img, img.Bounds(), image.NewUniform(artistColor(a.Artist)), image.Point{}, draw.Src)
+
+	fontSize := float64(size) / 10
+	if fontSize < 7 {
+		fontSize = 7
+	}
+	face, err := newCaptionFace(fontSize)
+	if err != nil {
+		return nil, err
+	}
+	defer face.Close()
+
+	padding := size / 16
+	lineHeight := face.Metrics().Height.Ceil()
+	maxLines := (size - 2*padding) / lineHeight
+
+	lines := wrapText(face, a.Artist, size-2*padding, maxLines/2+maxLines%2)
+	if a.Title != "" {
+		lines = append(lines, wrapText(face, a.Title, size-2*padding, maxLines-len(lines))...)
+	}
+
+	for l, line := range lines {
+		baseline := padding + l*lineHeight + face.Metrics().Ascent.Ceil()
+		drawText(img, face, line, padding, baseline, size-2*padding)
+	}
+	return img, nil
+}
+
+// artistColor derives a background colour from an artist's name
+func artistColor(artist string) color.Color {
+	h := fnv.New32a()
+	h.Write([]byte(strings.ToLower(artist)))
+	return hsvToRGB(float64(h.Sum32()%360), placeholderSat, placeholderValue)
+}
+
+// hsvToRGB converts a hue in degrees and saturation and value in [0,1] to a Go colour
+func hsvToRGB(h, s, v float64) color.Color {
+	c := v * s
+	x := c * (1 - math.Abs(math.Mod(h/60, 2)-1))
+	m := v - c
+
+	var r, g, b float64
+	switch {
+	case h < 60:
+		r, g, b = c, x, 0
+	case h < 120:
+		r, g, b = x, c, 0
+	case h < 180:
+		r, g, b = 0, c, x
+	case h < 240:
+		r, g, b = 0, x, c
+	case h < 300:
+		r, g, b = x, 0, c
+	default:
+		r, g, b = c, 0, x
+	}
+
+	return color.RGBA{uint8((r + m) * 255), uint8((g + m) * 255), uint8((b + m) * 255), 255}
+}
+
+// wrapText splits text into at most maxLines lines no wider than maxWidth pixels, breaking
+// between words. Anything that doesn't fit is cut short with an ellipsis by drawText.
+func wrapText(face font.Face, text string, maxWidth, maxLines int) []string {
+	if maxLines < 1 {
+		return nil
+	}
+
+	var lines []string
+	var line string
+	for _, word := range strings.Fields(text) {
+		candidate := word
+		if line != "" {
+			candidate = line + " " + word
+		}
+		if line == "" || font.MeasureString(face, candidate).Ceil() <= maxWidth {
+			line = candidate
+			continue
+		}
+
+		if len(lines) == maxLines-1 {
+			// Last line, keep the rest of the text on it to be cut short
+			line = candidate
+			continue
+		}
+		lines = append(lines, line)
+		line = word
+	}
+	if line != "" {
+		lines = append(lines, line)
+	}
+	return lines
+}
@@ -0,0 +1,61 @@
+package chart
+
+import (
+	"context"
+	"net/http"
+	"reflect"
+	"testing"
+)
+
+// coversWithGaps makes albums whose covers download, fail with a 404 or are missing
+func coversWithGaps(good, missing string) []album {
+	return []album{
+		{Title: "1", ImageURLS: albumImagesURL{Large: good + "/1", Medium: good + "/1"}},
+		{Title: "2", ImageURLS: albumImagesURL{Large: missing, Medium: missing}},
+		{Title: "3"},
+		{Title: "4", ImageURLS: albumImagesURL{Large: good + "/4", Medium: good + "/4"}},
+		{Title: "5", ImageURLS: albumImagesURL{Large: good + "/5", Medium: good + "/5"}},
+	}
+}
+
+func TestGetAlbumCoversPlaceholdersKeepGridFull(t *testing.T) {
+	good, _ := coverServer(http.StatusOK, 0)
+	defer good.Close()
+	// Every request to the missing server fails
+	missing, _ := coverServer(http.StatusNotFound, 1<<30)
+	defer missing.Close()
+
+	albums, err := getAlbumCovers(context.Background(), coversWithGaps(good.URL, missing.URL), 4, "Large", true, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := titles(albums), []string{"1", "2", "3", "4"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("albums = %v; want %v in place", got, want)
+	}
+	for _, a := range albums {
+		if a.Image == nil {
+			t.Fatalf("album %s has no image", a.Title)
+		}
+		if b := (*a.Image).Bounds(); b.Dx() != largeTileSize || b.Dy() != largeTileSize {
+			t.Errorf("album %s image is %v; want %dx%d", a.Title, b, largeTileSize, largeTileSize)
+		}
+	}
+
+	// Without placeholders the albums without covers are dropped and later albums fill the grid
+	albums, err = getAlbumCovers(context.Background(), coversWithGaps(good.URL, missing.URL), 3, "Large", false, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := titles(albums), []string{"1", "4", "5"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("albums = %v; want %v", got, want)
+	}
+}
+
+func TestArtistColorIsStable(t *testing.T) {
+	if artistColor("Radiohead") != artistColor("radiohead") {
+		t.Error("artistColor() differs by case; want an artist's placeholders to match")
+	}
+	if artistColor("Radiohead") == artistColor("Aphex Twin") {
+		t.Error("artistColor() is the same for different artists")
+	}
+}
@@ -0,0 +1,129 @@
+package chart
+
+import (
+	"fmt"
+	"log"
+	"net/url"
+	"strconv"
+	"sync"
+
+	"github.com/conorbros/las-tools/conf"
+	"github.com/conorbros/las-tools/lastfm"
+	"github.com/conorbros/las-tools/spotify"
+)
+
+type topTracksResponse struct {
+	Toptracks struct {
+		Track []struct {
+			Artist struct {
+				Name string `json:"name"`
+			} `json:"artist"`
+			Name      string `json:"name"`
+			Playcount string `json:"playcount"`
+		} `json:"track"`
+	} `json:"toptracks"`
+}
+
+// getLastFmTopTracks gets the user's top tracks for the period with the cover of the album each
+// track is on in Spotify. When collapse is true tracks sharing a cover are merged into the
+// highest ranked of them, so one album can't fill the chart.
+func getLastFmTopTracks(username string, count int, period string, collapse bool) ([]album, error) {
+	// add 50 to the count as a buffer against downloads that fail, and fetch twice as many
+	// when collapsing as many of the tracks may be merged away
+	limit := count + 50
+	if collapse {
+		limit = count*2 + 50
+	}
+	limit = lastFmLimit(limit)
+
+	urlParams := fmt.Sprintf("&user=%s&api_key=%s&format=json&period=%s&limit=%d", url.QueryEscape(username), conf.Config.LastFm.APIKey, period, limit)
+
+	var response topTracksResponse
+	if err := lastfm.GetCachedJSON(conf.Config.LastFm.UserTopTracksEndpoint+urlParams, &response); err != nil {
+		return nil, err
+	}
+
+	var tracks []album
+	for _, t := range response.Toptracks.Track {
+		playcount, err := strconv.ParseUint(t.Playcount, 10, 64)
+		if err != nil {
+			playcount = 0
+		}
+		tracks = append(tracks, album{
+			Artist:    t.Artist.Name,
+			Title:     t.Name,
+			Playcount: playcount,
+		})
+	}
+
+	tracks, err := resolveTrackImages(tracks)
+	if err != nil {
+		return nil, err
+	}
+
+	if collapse {
+		tracks = collapseDuplicateCovers(tracks)
+	}
+	return tracks, nil
+}
+
+// resolveTrackImages finds each track on Spotify and sets its image urls to the cover of the
+// album it appears on. Tracks that can't be found are left without image urls.
+func resolveTrackImages(tracks []album) ([]album, error) {
+	// Fail before searching if there is no token, later searches get it again as it can expire
+	if _, err := spotify.GetClientAccessToken(); err != nil {
+		return nil, err
+	}
+
+	var wg sync.WaitGroup
+	sem := make(chan struct{}, spotify.SearchConcurrency)
+
+	for i := range tracks {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			sem <- struct{}{}
+			defer func() { <-sem }()
+
+			var spotifyAlbum spotify.Album
+			err := spotify.WithClientToken(func(clientAccessToken string) (err error) {
+				spotifyAlbum, err = spotify.GetTrackAlbum(tracks[i].Artist, tracks[i].Title, clientAccessToken)
+				return err
+			})
+			if err != nil {
+				log.Print(err)
+				return
+			}
+			if len(spotifyAlbum.Images) == 0 {
+				return
+			}
+
+			tracks[i].ImageURLS = makeSpotifyImagesURL(spotifyAlbum.Images)
+		}(i)
+	}
+
+	wg.Wait()
+	return tracks, nil
+}
+
+// collapseDuplicateCovers merges tracks that share a cover into the first of them,
+// adding their playcounts together
+func collapseDuplicateCovers(tracks []album) []album {
+	seen := make(map[string]int)
+
+	var collapsed []album
+	for _, t := range tracks {
+		// Tracks without a cover can't be duplicates of each other
+		if !t.hasArt() {
+			collapsed = append(collapsed, t)
+			continue
+		}
+		if i, ok := seen[t.ImageURLS.Large]; ok {
+			collapsed[i].Playcount += t.Playcount
+			continue
+		}
+		seen[t.ImageURLS.Large] = len(collapsed)
+		collapsed = append(collapsed, t)
+	}
+	return collapsed
+}
@@ -0,0 +1,52 @@
+package chart
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestCollapseDuplicateCovers(t *testing.T) {
+	cover := func(url string) albumImagesURL {
+		return albumImagesURL{Large: url, Medium: url}
+	}
+
+	tests := []struct {
+		name   string
+		tracks []album
+		want   []album
+	}{
+		{
+			name:   "no duplicates",
+			tracks: []album{{Title: "1", Playcount: 5, ImageURLS: cover("a")}, {Title: "2", Playcount: 3, ImageURLS: cover("b")}},
+			want:   []album{{Title: "1", Playcount: 5, ImageURLS: cover("a")}, {Title: "2", Playcount: 3, ImageURLS: cover("b")}},
+		},
+		{
+			name: "same album repeated",
+			tracks: []album{
+				{Title: "1", Playcount: 5, ImageURLS: cover("a")},
+				{Title: "2", Playcount: 4, ImageURLS: cover("b")},
+				{Title: "3", Playcount: 3, ImageURLS: cover("a")},
+				{Title: "4", Playcount: 2, ImageURLS: cover("a")},
+			},
+			want: []album{{Title: "1", Playcount: 10, ImageURLS: cover("a")}, {Title: "2", Playcount: 4, ImageURLS: cover("b")}},
+		},
+		{
+			name:   "tracks without covers",
+			tracks: []album{{Title: "1", Playcount: 5}, {Title: "2", Playcount: 3}, {Title: "3", Playcount: 1, ImageURLS: cover("a")}},
+			want:   []album{{Title: "1", Playcount: 5}, {Title: "2", Playcount: 3}, {Title: "3", Playcount: 1, ImageURLS: cover("a")}},
+		},
+		{
+			name:   "no tracks",
+			tracks: nil,
+			want:   nil,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := collapseDuplicateCovers(tt.tracks); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("collapseDuplicateCovers() = %+v; want %+v", got, tt.want)
+			}
+		})
+	}
+}
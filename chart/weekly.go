@@ -0,0 +1,214 @@
+package chart
+
+import (
+	"fmt"
+	"log"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/conorbros/las-tools/conf"
+	"github.com/conorbros/las-tools/lastfm"
+)
+
+// maxDateRange is the longest date range a chart can be generated for. Each week in the
+// range costs a request to Last.fm so this keeps large ranges from hammering the API.
+const maxDateRange = 2 * 366 * 24 * time.Hour
+
+// lastFmConcurrency is the maximum number of Last.fm requests made at once when
+// building a date range chart
+const lastFmConcurrency = 8
+
+type weeklyChartListResponse struct {
+	Weeklychartlist struct {
+		Chart []struct {
+			From string `json:"from"`
+			To   string `json:"to"`
+		} `json:"chart"`
+	} `json:"weeklychartlist"`
+}
+
+type weeklyAlbumChartResponse struct {
+	Weeklyalbumchart struct {
+		Album []struct {
+			Artist struct {
+				Text string `json:"#text"`
+			} `json:"artist"`
+			Name      string `json:"name"`
+			Playcount string `json:"playcount"`
+		} `json:"album"`
+	} `json:"weeklyalbumchart"`
+}
+
+type albumInfoResponse struct {
+	Album struct {
+		Image []imageResponse `json:"image"`
+	} `json:"album"`
+}
+
+// chartWeek is a single week from the user's weekly chart list, as unix timestamps
+type chartWeek struct {
+	From int64
+	To   int64
+}
+
+// getLastFmDateRangeAlbums builds a list of the user's top albums between from and to by summing
+// the playcounts of every weekly album chart that intersects the range. The weekly charts don't
+// include album art so the images for each album are looked up with album.getInfo.
+func getLastFmDateRangeAlbums(username string, count int, from, to time.Time) ([]album, error) {
+	weeks, err := getLastFmWeeks(username, from, to)
+	if err != nil {
+		return nil, err
+	}
+
+	albums, err := getLastFmWeeklyAlbums(username, weeks)
+	if err != nil {
+		return nil, err
+	}
+
+	// add 50 to the count as a buffer against downloads that fail, as getLastFmTopAlbums does
+	if len(albums) > count+50 {
+		albums = albums[:count+50]
+	}
+
+	return resolveAlbumImages(albums), nil
+}
+
+// getLastFmWeeks gets the weeks from the user's weekly chart list that intersect from and to
+func getLastFmWeeks(username string, from, to time.Time) ([]chartWeek, error) {
+	urlParams := fmt.Sprintf("&user=%s&api_key=%s&format=json", url.QueryEscape(username), conf.Config.LastFm.APIKey)
+
+	var response weeklyChartListResponse
+	if err := lastfm.GetJSON(conf.Config.LastFm.UserWeeklyChartListEndpoint+urlParams, &response); err != nil {
+		return nil, err
+	}
+
+	var weeks []chartWeek
+	for _, c := range response.Weeklychartlist.Chart {
+		weekFrom, err := strconv.ParseInt(c.From, 10, 64)
+		if err != nil {
+			continue
+		}
+		weekTo, err := strconv.ParseInt(c.To, 10, 64)
+		if err != nil {
+			continue
+		}
+
+		if weekFrom < to.Unix() && weekTo > from.Unix() {
+			weeks = append(weeks, chartWeek{From: weekFrom, To: weekTo})
+		}
+	}
+	return weeks, nil
+}
+
+// getLastFmWeeklyAlbums gets the weekly album chart for each week and merges them into a single
+// list of albums ordered by their total playcount
+func getLastFmWeeklyAlbums(username string, weeks []chartWeek) ([]album, error) {
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	var firstErr error
+	sem := make(chan struct{}, lastFmConcurrency)
+
+	totals := make(map[string]*album)
+
+	for _, week := range weeks {
+		wg.Add(1)
+		go func(week chartWeek) {
+			defer wg.Done()
+			sem <- struct{}{}
+			defer func() { <-sem }()
+
+			urlParams := fmt.Sprintf("&user=%s&api_key=%s&format=json&from=%d&to=%d", url.QueryEscape(username), conf.Config.LastFm.APIKey, week.From, week.To)
+
+			var response weeklyAlbumChartResponse
+			err := lastfm.GetJSON(conf.Config.LastFm.UserWeeklyAlbumChartEndpoint+urlParams, &response)
+
+			mu.Lock()
+			defer mu.Unlock()
+
+			if err != nil {
+				if firstErr == nil {
+					firstErr = err
+				}
+				return
+			}
+
+			for _, a := range response.Weeklyalbumchart.Album {
+				playcount, err := strconv.ParseUint(a.Playcount, 10, 64)
+				if err != nil {
+					playcount = 0
+				}
+
+				key := strings.ToLower(a.Artist.Text + "\x00" + a.Name)
+				if total, ok := totals[key]; ok {
+					total.Playcount += playcount
+					continue
+				}
+				totals[key] = &album{
+					Artist:    a.Artist.Text,
+					Title:     a.Name,
+					Playcount: playcount,
+				}
+			}
+		}(week)
+	}
+
+	wg.Wait()
+
+	if firstErr != nil {
+		return nil, firstErr
+	}
+
+	albums := make([]album, 0, len(totals))
+	for _, a := range totals {
+		albums = append(albums, *a)
+	}
+
+	sort.SliceStable(albums, func(i, j int) bool {
+		if albums[i].Playcount == albums[j].Playcount {
+			if albums[i].Artist == albums[j].Artist {
+				return albums[i].Title < albums[j].Title
+			}
+			return albums[i].Artist < albums[j].Artist
+		}
+		return albums[i].Playcount > albums[j].Playcount
+	})
+
+	return albums, nil
+}
+
+// resolveAlbumImages looks up the image urls for each album with album.getInfo. Albums
+// without any art are left without image urls.
+func resolveAlbumImages(albums []album) []album {
+	var wg sync.WaitGroup
+	sem := make(chan struct{}, lastFmConcurrency)
+
+	for i := range albums {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			sem <- struct{}{}
+			defer func() { <-sem }()
+
+			urlParams := fmt.Sprintf("&artist=%s&album=%s&api_key=%s&format=json&autocorrect=1", url.QueryEscape(albums[i].Artist), url.QueryEscape(albums[i].Title), conf.Config.LastFm.APIKey)
+
+			var response albumInfoResponse
+			if err := lastfm.GetJSON(conf.Config.LastFm.AlbumInfoEndpoint+urlParams, &response); err != nil {
+				log.Print(err)
+				return
+			}
+
+			images, err := makeAlbumImagesURL(response.Album.Image)
+			if err != nil {
+				return
+			}
+			albums[i].ImageURLS = images
+		}(i)
+	}
+
+	wg.Wait()
+	return albums
+}
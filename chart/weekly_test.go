@@ -0,0 +1,99 @@
+package chart
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/conorbros/las-tools/conf"
+)
+
+// weeklyCharts are the weekly album charts served by weeklyServer, by the start of their week
+var weeklyCharts = map[string]string{
+	"100": `[{"artist":{"#text":"Artist A"},"name":"Album X","playcount":"5"},
+		{"artist":{"#text":"Artist B"},"name":"Album Y","playcount":"3"},
+		{"artist":{"#text":"Artist C"},"name":"Album Z","playcount":"3"}]`,
+	"200": `[{"artist":{"#text":"artist a"},"name":"ALBUM X","playcount":"2"},
+		{"artist":{"#text":"Artist B"},"name":"Album W","playcount":"6"},
+		{"artist":{"#text":"Artist B"},"name":"Album V","playcount":"3"}]`,
+	"300": `[{"artist":{"#text":"Artist D"},"name":"Album Q","playcount":"50"}]`,
+}
+
+// weeklyServer serves a weekly chart list of four weeks and the album chart for each week
+func weeklyServer() *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		q := r.URL.Query()
+		switch q.Get("method") {
+		case "user.getweeklychartlist":
+			fmt.Fprint(w, `{"weeklychartlist":{"chart":[{"from":"0","to":"100"},{"from":"100","to":"200"},{"from":"200","to":"300"},{"from":"300","to":"400"}]}}`)
+		case "user.getweeklyalbumchart":
+			albums, ok := weeklyCharts[q.Get("from")]
+			if !ok {
+				albums = "[]"
+			}
+			fmt.Fprintf(w, `{"weeklyalbumchart":{"album":%s}}`, albums)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+}
+
+func TestGetLastFmWeeks(t *testing.T) {
+	server := weeklyServer()
+	defer server.Close()
+	conf.Config.LastFm.UserWeeklyChartListEndpoint = server.URL + "/?method=user.getweeklychartlist"
+
+	tests := []struct {
+		from, to int64
+		want     []chartWeek
+	}{
+		{150, 250, []chartWeek{{100, 200}, {200, 300}}},
+		// Weeks that only touch the range at its ends don't intersect it
+		{200, 300, []chartWeek{{200, 300}}},
+		{500, 600, nil},
+	}
+	for _, tt := range tests {
+		weeks, err := getLastFmWeeks("rj", time.Unix(tt.from, 0), time.Unix(tt.to, 0))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if fmt.Sprint(weeks) != fmt.Sprint(tt.want) {
+			t.Errorf("getLastFmWeeks(%d, %d) = %v; want %v", tt.from, tt.to, weeks, tt.want)
+		}
+	}
+}
+
+func TestGetLastFmWeeklyAlbums(t *testing.T) {
+	server := weeklyServer()
+	defer server.Close()
+	conf.Config.LastFm.UserWeeklyAlbumChartEndpoint = server.URL + "/?method=user.getweeklyalbumchart"
+
+	albums, err := getLastFmWeeklyAlbums("rj", []chartWeek{{100, 200}, {200, 300}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Album X is merged across weeks whatever its case. Albums with the same playcount are
+	// ordered by artist and then title.
+	want := []struct {
+		title     string
+		playcount uint64
+	}{
+		{"album x", 7},
+		{"album w", 6},
+		{"album v", 3},
+		{"album y", 3},
+		{"album z", 3},
+	}
+	if len(albums) != len(want) {
+		t.Fatalf("got %d albums; want %d: %+v", len(albums), len(want), albums)
+	}
+	for i, w := range want {
+		if strings.ToLower(albums[i].Title) != w.title || albums[i].Playcount != w.playcount {
+			t.Errorf("albums[%d] = %s with %d plays; want %s with %d", i, albums[i].Title, albums[i].Playcount, w.title, w.playcount)
+		}
+	}
+}
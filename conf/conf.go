@@ -4,22 +4,47 @@ import (
 	"encoding/json"
 	"log"
 	"os"
+	"path/filepath"
 )
 
 var spotifyAuthScopes = []string{"user-follow-read", "user-read-recently-played", "playlist-read-private", "user-follow-read", "user-top-read", "user-library-read", "user-library-modify", "playlist-modify-private", "playlist-modify-public"}
 
+const lastFmAPIRoot = "https://ws.audioscrobbler.com/2.0/"
+
+const spotifyAPIRoot = "https://api.spotify.com/v1/"
+
+// confPath is the file the configuration is read from
+const confPath = "./conf/conf.json"
+
 // Config stores constant variables for the applicaiton
 var Config *Configuration
 
+// loadErr is why conf.json couldn't be read when Config was loaded
+var loadErr error
+
 func init() {
-	Config = New()
+	Config, loadErr = load(confPath)
+}
+
+// MustLoad exits if conf.json couldn't be read. The app calls it when it starts so it never runs
+// without its configuration, while packages tested without a conf.json use the defaults.
+func MustLoad() {
+	if loadErr != nil {
+		log.Fatal(loadErr)
+	}
 }
 
 // LastFmConfig holds configuration options for the LastFm API
 type LastFmConfig struct {
-	APIKey                string
-	UserTopTracksEndpoint string
-	UserTopAlbumsEndpoint string
+	APIKey                       string
+	UserTopTracksEndpoint        string
+	UserTopAlbumsEndpoint        string
+	UserTopArtistsEndpoint       string
+	UserWeeklyChartListEndpoint  string
+	UserWeeklyAlbumChartEndpoint string
+	AlbumInfoEndpoint            string
+	UserLovedTracksEndpoint      string
+	UserRecentTracksEndpoint     string
 }
 
 // SpotifyConfig holds configuration options for the Spotify API
@@ -34,30 +59,79 @@ type SpotifyConfig struct {
 	ClientID                 string
 	ClientSecret             string
 	SearchEndpoint           string
+	// CurrentUserPlaylistsEndpoint lists the playlists of the logged in user
+	CurrentUserPlaylistsEndpoint string
+	// SavedTracksEndpoint saves tracks to the logged in user's Liked Songs
+	SavedTracksEndpoint string
+	// ContainsSavedTracksEndpoint checks whether tracks are in the logged in user's Liked Songs
+	ContainsSavedTracksEndpoint string
+}
+
+// ChartConfig holds configuration options for generating charts
+type ChartConfig struct {
+	// DownloadWorkers is the number of covers downloaded at once for each chart
+	DownloadWorkers int
+	// DownloadTimeoutSeconds is how long a single cover download can take
+	DownloadTimeoutSeconds int
+	// DownloadRetries is how many times a cover download is retried after a timeout or server
+	// error. A negative value turns retries off.
+	DownloadRetries int
+	// CacheMemoryEntries is the number of resized covers kept in memory
+	CacheMemoryEntries int
+	// CacheDir is the directory covers are cached in on disk
+	CacheDir string
+	// CacheDiskMB is the most space the disk cache can use. A negative value turns the disk cache off.
+	CacheDiskMB int
+	// CacheTTLHours is how long a cached cover is used before it is downloaded again
+	CacheTTLHours int
+	// JobTTLMinutes is how long a chart job and its image are kept after the job is created
+	JobTTLMinutes int
+	// MaxJobs is the most chart jobs kept at once, counting finished jobs that haven't expired
+	MaxJobs int
+	// MaxRunningJobs is the most chart jobs generated at once
+	MaxRunningJobs int
+}
+
+// SyncConfig holds configuration options for scheduled playlist syncs
+type SyncConfig struct {
+	// StorePath is the file sync definitions and their Spotify refresh tokens are kept in
+	StorePath string
+	// CheckIntervalSeconds is how often the scheduler looks for syncs that are due
+	CheckIntervalSeconds int
 }
 
 // Configuration holds the configuration data for this instance of the app
 type Configuration struct {
 	Spotify SpotifyConfig
 	LastFm  LastFmConfig
+	Chart   ChartConfig
+	Sync    SyncConfig
 	Port    string
 }
 
-// New creates a new configuration struct for the application
+// New creates a new configuration struct for the application, exiting if conf.json can't be read
 func New() *Configuration {
-	config := Configuration{}
-
-	file, err := os.Open("./conf/conf.json")
+	config, err := load(confPath)
 	if err != nil {
 		log.Fatal(err)
 	}
-	defer file.Close()
-	decoder := json.NewDecoder(file)
-	err = decoder.Decode(&config)
+	return config
+}
+
+// load reads the configuration from the file at path and fills in the defaults. If the file can't
+// be read the error is returned along with the defaults.
+func load(path string) (*Configuration, error) {
+	config := Configuration{}
+	err := readFile(path, &config)
 	if err != nil {
-		log.Fatal(err)
+		config = Configuration{}
 	}
 
+	setLastFmDefaults(&config.LastFm)
+	setSpotifyDefaults(&config.Spotify)
+	setChartDefaults(&config.Chart)
+	setSyncDefaults(&config.Sync)
+
 	spotifyRedirectURI := os.Getenv("SPOTIFY_REDIRECT_URL")
 	if spotifyRedirectURI == "" {
 		spotifyRedirectURI = "http://localhost:8080/playlist"
@@ -71,5 +145,94 @@ func New() *Configuration {
 	}
 	config.Port = port
 
-	return &config
+	return &config, err
+}
+
+// readFile decodes the JSON configuration file at path into config
+func readFile(path string, config *Configuration) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	return json.NewDecoder(file).Decode(config)
+}
+
+// setLastFmDefaults fills in any Last.fm endpoints missing from conf.json
+func setLastFmDefaults(c *LastFmConfig) {
+	if c.UserTopArtistsEndpoint == "" {
+		c.UserTopArtistsEndpoint = lastFmAPIRoot + "?method=user.gettopartists"
+	}
+	if c.UserWeeklyChartListEndpoint == "" {
+		c.UserWeeklyChartListEndpoint = lastFmAPIRoot + "?method=user.getweeklychartlist"
+	}
+	if c.UserWeeklyAlbumChartEndpoint == "" {
+		c.UserWeeklyAlbumChartEndpoint = lastFmAPIRoot + "?method=user.getweeklyalbumchart"
+	}
+	if c.AlbumInfoEndpoint == "" {
+		c.AlbumInfoEndpoint = lastFmAPIRoot + "?method=album.getinfo"
+	}
+	if c.UserLovedTracksEndpoint == "" {
+		c.UserLovedTracksEndpoint = lastFmAPIRoot + "?method=user.getlovedtracks"
+	}
+	if c.UserRecentTracksEndpoint == "" {
+		c.UserRecentTracksEndpoint = lastFmAPIRoot + "?method=user.getrecenttracks"
+	}
+}
+
+// setSpotifyDefaults fills in any Spotify endpoints missing from conf.json
+func setSpotifyDefaults(c *SpotifyConfig) {
+	if c.CurrentUserPlaylistsEndpoint == "" {
+		c.CurrentUserPlaylistsEndpoint = spotifyAPIRoot + "me/playlists"
+	}
+	if c.SavedTracksEndpoint == "" {
+		c.SavedTracksEndpoint = spotifyAPIRoot + "me/tracks"
+	}
+	if c.ContainsSavedTracksEndpoint == "" {
+		c.ContainsSavedTracksEndpoint = spotifyAPIRoot + "me/tracks/contains"
+	}
+}
+
+// setChartDefaults fills in any chart options missing from conf.json
+func setChartDefaults(c *ChartConfig) {
+	if c.DownloadWorkers <= 0 {
+		c.DownloadWorkers = 32
+	}
+	if c.DownloadTimeoutSeconds <= 0 {
+		c.DownloadTimeoutSeconds = 10
+	}
+	if c.DownloadRetries == 0 {
+		c.DownloadRetries = 2
+	}
+	if c.CacheMemoryEntries <= 0 {
+		c.CacheMemoryEntries = 2000
+	}
+	if c.CacheDir == "" {
+		c.CacheDir = filepath.Join(os.TempDir(), "las-tools", "covers")
+	}
+	if c.CacheDiskMB == 0 {
+		c.CacheDiskMB = 512
+	}
+	if c.CacheTTLHours <= 0 {
+		c.CacheTTLHours = 7 * 24
+	}
+	if c.JobTTLMinutes <= 0 {
+		c.JobTTLMinutes = 15
+	}
+	if c.MaxJobs <= 0 {
+		c.MaxJobs = 50
+	}
+	if c.MaxRunningJobs <= 0 {
+		c.MaxRunningJobs = 4
+	}
+}
+
+// setSyncDefaults fills in any sync options missing from conf.json
+func setSyncDefaults(c *SyncConfig) {
+	if c.StorePath == "" {
+		c.StorePath = filepath.Join("data", "syncs.json")
+	}
+	if c.CheckIntervalSeconds <= 0 {
+		c.CheckIntervalSeconds = 60
+	}
 }
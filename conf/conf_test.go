@@ -1,16 +1,43 @@
 package conf
 
 import (
+	"io/ioutil"
 	"os"
+	"path/filepath"
 	"testing"
 )
 
-func TestNew(t *testing.T) {
+func TestLoad(t *testing.T) {
 	os.Setenv("SPOTIFY_REDIRECT_URL", "")
 
-	config := New()
+	dir, err := ioutil.TempDir("", "conf")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "conf.json")
+	if err := ioutil.WriteFile(path, []byte(`{"LastFm":{"APIKey":"key"}}`), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	config, err := load(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if config.LastFm.APIKey != "key" {
+		t.Errorf("LastFm.APIKey = %s; want key", config.LastFm.APIKey)
+	}
+	if config.Spotify.RedirectURI != "http://localhost:8080/playlist" {
+		t.Errorf("Spotify.RedirectURI = %s; want http://localhost:8080/playlist", config.Spotify.RedirectURI)
+	}
+}
 
-	if config.SpotifyRedirectURI != "localhost:8080" {
-		t.Errorf("SpotifyRedirectURI = %s; want localhost:8080", config.SpotifyRedirectURI)
+func TestLoadMissingFile(t *testing.T) {
+	config, err := load(filepath.Join("missing", "conf.json"))
+	if !os.IsNotExist(err) {
+		t.Errorf("err = %v; want the file not to exist", err)
+	}
+	if config.Chart.MaxJobs != 50 || config.LastFm.UserTopArtistsEndpoint == "" {
+		t.Errorf("config = %+v; want the defaults", config)
 	}
 }
@@ -8,4 +8,5 @@ require (
 	github.com/oliamb/cutter v0.2.2 // indirect
 	github.com/ozankasikci/go-image-merge v0.2.3-0.20200725062803-aedf4eb2eb2c
 	github.com/rainycape/magick v0.0.0-20160302153252-3156ecd81fb6 // indirect
+	golang.org/x/image v0.0.0-20200927104501-e162460cd6b5
 )
@@ -0,0 +1,200 @@
+package lastfm
+
+import (
+	"container/list"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// maxCacheEntries is the number of responses kept in the cache
+const maxCacheEntries = 200
+
+// periodTTLs is how long a cached response is fresh for each Last.fm period. Short periods
+// change quickly as the user scrobbles while all time charts barely move.
+var periodTTLs = map[string]time.Duration{
+	"7day":    5 * time.Minute,
+	"1month":  15 * time.Minute,
+	"3month":  30 * time.Minute,
+	"6month":  time.Hour,
+	"12month": 2 * time.Hour,
+	"overall": 6 * time.Hour,
+}
+
+// defaultTTL is used for responses that don't have a period
+const defaultTTL = 5 * time.Minute
+
+var client = http.Client{
+	Timeout: time.Duration(60 * time.Second),
+}
+
+var cache = newResponseCache(maxCacheEntries)
+
+// codeInvalidParameters is the Last.fm error code sent for bad parameters, including unknown users
+const codeInvalidParameters = 6
+
+// Error is an error response from the Last.fm API, such as for an unknown user
+type Error struct {
+	Code    int    `json:"error"`
+	Message string `json:"message"`
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("Last.fm error %d: %s", e.Code, e.Message)
+}
+
+// IsInvalidParameters reports whether err is a Last.fm invalid parameters error, which is
+// what Last.fm responds with when the user doesn't exist
+func IsInvalidParameters(err error) bool {
+	lastFmErr, ok := err.(*Error)
+	return ok && lastFmErr.Code == codeInvalidParameters
+}
+
+// GetJSON makes a GET request to a Last.fm API url and decodes the JSON response into v
+func GetJSON(apiURL string, v interface{}) error {
+	body, err := get(apiURL)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(body, v)
+}
+
+// GetCachedJSON works like GetJSON but reuses the response to an earlier request with the same
+// parameters while it is fresh. How long a response stays fresh depends
+// on its period. If refreshing a stale response fails, the stale response is used instead.
+func GetCachedJSON(apiURL string, v interface{}) error {
+	key, ttl, err := cacheKey(apiURL)
+	if err != nil {
+		return err
+	}
+
+	cached, fresh := cache.get(key)
+	if fresh {
+		return json.Unmarshal(cached, v)
+	}
+
+	body, err := get(apiURL)
+	if err != nil {
+		if cached != nil {
+			return json.Unmarshal(cached, v)
+		}
+		return err
+	}
+
+	cache.put(key, body, ttl)
+	return json.Unmarshal(body, v)
+}
+
+// get requests the url and returns the body of a successful response
+func get(apiURL string) ([]byte, error) {
+	req, err := http.NewRequest(http.MethodGet, apiURL, strings.NewReader(""))
+	if err != nil {
+		return nil, err
+	}
+
+	res, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+
+	defer res.Body.Close()
+	body, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	var lastFmErr Error
+	if json.Unmarshal(body, &lastFmErr) == nil && lastFmErr.Code != 0 {
+		return nil, &lastFmErr
+	}
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("Last.fm responded with status %d", res.StatusCode)
+	}
+	return body, nil
+}
+
+// cacheKey builds the cache key for a Last.fm API url from its endpoint and every parameter but the
+// api key, so requests only share a response when they ask for the same thing, and gets how long
+// the response is fresh for. Methods and usernames are case insensitive so they are lowercased.
+func cacheKey(apiURL string) (string, time.Duration, error) {
+	u, err := url.Parse(apiURL)
+	if err != nil {
+		return "", 0, err
+	}
+	q := u.Query()
+
+	ttl, ok := periodTTLs[q.Get("period")]
+	if !ok {
+		ttl = defaultTTL
+	}
+
+	q.Del("api_key")
+	for _, name := range []string{"method", "user"} {
+		if v, ok := q[name]; ok {
+			q.Set(name, strings.ToLower(strings.Join(v, ",")))
+		}
+	}
+	// Encode sorts the parameters by name
+	return u.Host + u.Path + "?" + q.Encode(), ttl, nil
+}
+
+type cacheEntry struct {
+	key     string
+	body    []byte
+	expires time.Time
+}
+
+// responseCache is an LRU of Last.fm response bodies
+type responseCache struct {
+	mu         sync.Mutex
+	maxEntries int
+	lru        *list.List
+	entries    map[string]*list.Element
+}
+
+func newResponseCache(maxEntries int) *responseCache {
+	return &responseCache{
+		maxEntries: maxEntries,
+		lru:        list.New(),
+		entries:    make(map[string]*list.Element),
+	}
+}
+
+// get gets the cached body for the key, and whether it is still fresh. Stale bodies are
+// returned so they can be used if refreshing them fails.
+func (c *responseCache) get(key string) ([]byte, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	el, ok := c.entries[key]
+	if !ok {
+		return nil, false
+	}
+	c.lru.MoveToFront(el)
+	entry := el.Value.(*cacheEntry)
+	return entry.body, time.Now().Before(entry.expires)
+}
+
+func (c *responseCache) put(key string, body []byte, ttl time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	entry := &cacheEntry{key: key, body: body, expires: time.Now().Add(ttl)}
+	if el, ok := c.entries[key]; ok {
+		el.Value = entry
+		c.lru.MoveToFront(el)
+		return
+	}
+
+	c.entries[key] = c.lru.PushFront(entry)
+	for c.lru.Len() > c.maxEntries {
+		oldest := c.lru.Back()
+		c.lru.Remove(oldest)
+		delete(c.entries, oldest.Value.(*cacheEntry).key)
+	}
+}
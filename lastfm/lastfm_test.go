@@ -0,0 +1,135 @@
+package lastfm
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+type countResponse struct {
+	Count int `json:"count"`
+}
+
+// countingServer responds with the number of requests it has received
+func countingServer(fail *bool) (*httptest.Server, *int) {
+	var hits int
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if fail != nil && *fail {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		hits++
+		if r.URL.Query().Get("user") == "nobody" {
+			fmt.Fprint(w, `{"error":6,"message":"User not found"}`)
+			return
+		}
+		fmt.Fprintf(w, `{"count":%d}`, hits)
+	})), &hits
+}
+
+func TestGetCachedJSONReusesResponses(t *testing.T) {
+	cache = newResponseCache(maxCacheEntries)
+	server, hits := countingServer(nil)
+	defer server.Close()
+
+	url := server.URL + "/?method=user.gettopalbums&user=RJ&period=overall&limit=10&api_key=a"
+	for i := 0; i < 3; i++ {
+		var res countResponse
+		if err := GetCachedJSON(url, &res); err != nil {
+			t.Fatal(err)
+		}
+		if res.Count != 1 {
+			t.Errorf("response %d has count %d; want the first response", i, res.Count)
+		}
+	}
+
+	// Usernames are case insensitive and the api key isn't part of the key
+	var res countResponse
+	GetCachedJSON(server.URL+"/?method=user.gettopalbums&user=rj&period=overall&limit=10&api_key=b", &res)
+	if *hits != 1 {
+		t.Errorf("server was hit %d times; want 1", *hits)
+	}
+
+	// A different period is a different request
+	GetCachedJSON(server.URL+"/?method=user.gettopalbums&user=RJ&period=7day&limit=10", &res)
+	if *hits != 2 {
+		t.Errorf("server was hit %d times; want 2", *hits)
+	}
+}
+
+func TestCacheKey(t *testing.T) {
+	tests := []struct {
+		name  string
+		a, b  string
+		equal bool
+	}{
+		{"parameter order", "/?method=user.getrecenttracks&user=RJ&from=1&to=2", "/?to=2&from=1&user=RJ&method=user.getrecenttracks", true},
+		{"api key", "/?method=user.gettopalbums&user=RJ&api_key=a", "/?method=user.gettopalbums&user=RJ&api_key=b", true},
+		{"username case", "/?method=user.gettopalbums&user=RJ", "/?method=user.gettopalbums&user=rj", true},
+		{"from", "/?method=user.getrecenttracks&user=RJ&from=1&to=3", "/?method=user.getrecenttracks&user=RJ&from=2&to=3", false},
+		{"to", "/?method=user.getrecenttracks&user=RJ&from=1&to=2", "/?method=user.getrecenttracks&user=RJ&from=1&to=3", false},
+		{"artist", "/?method=artist.gettopalbums&artist=a", "/?method=artist.gettopalbums&artist=b", false},
+		{"method", "/?method=user.gettopalbums&user=RJ", "/?method=user.gettoptracks&user=RJ", false},
+	}
+
+	for _, test := range tests {
+		a, _, err := cacheKey(test.a)
+		if err != nil {
+			t.Fatal(err)
+		}
+		b, _, err := cacheKey(test.b)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if (a == b) != test.equal {
+			t.Errorf("%s: keys %q and %q equal = %t; want %t", test.name, a, b, a == b, test.equal)
+		}
+	}
+}
+
+func TestGetCachedJSONServesStaleOnFailure(t *testing.T) {
+	cache = newResponseCache(maxCacheEntries)
+	fail := false
+	server, _ := countingServer(&fail)
+	defer server.Close()
+
+	url := server.URL + "/?method=user.gettoptracks&user=RJ&period=7day&limit=10"
+	key, _, _ := cacheKey(url)
+	cache.put(key, []byte(`{"count":42}`), -time.Second)
+
+	fail = true
+	var res countResponse
+	if err := GetCachedJSON(url, &res); err != nil {
+		t.Fatal(err)
+	}
+	if res.Count != 42 {
+		t.Errorf("count = %d; want the stale response", res.Count)
+	}
+
+	fail = false
+	if err := GetCachedJSON(url, &res); err != nil {
+		t.Fatal(err)
+	}
+	if res.Count != 1 {
+		t.Errorf("count = %d; want the stale response refreshed", res.Count)
+	}
+}
+
+func TestGetCachedJSONDoesNotCacheErrors(t *testing.T) {
+	cache = newResponseCache(maxCacheEntries)
+	server, hits := countingServer(nil)
+	defer server.Close()
+
+	url := server.URL + "/?method=user.gettopalbums&user=nobody&period=overall&limit=10"
+	for i := 0; i < 2; i++ {
+		var res countResponse
+		if err := GetCachedJSON(url, &res); !IsInvalidParameters(err) {
+			t.Errorf("err = %v; want an invalid parameters error", err)
+		}
+	}
+	if *hits != 2 {
+		t.Errorf("server was hit %d times; want 2", *hits)
+	}
+}
@@ -25,6 +25,7 @@ func indexHandler(w http.ResponseWriter, r *http.Request) {
 }
 
 func main() {
+	conf.MustLoad()
 
 	// Serve the static files from the static directory in web
 	fs := http.FileServer(http.Dir("web/static"))
@@ -39,9 +40,27 @@ func main() {
 	finalPlaylistHandler := http.HandlerFunc(playlist.PortTopTracksHandler)
 	mux.Handle("/port_toptracks", middleware.SpotifyAuthRequired(finalPlaylistHandler))
 
+	streamPlaylistHandler := http.HandlerFunc(playlist.PortTopTracksStreamHandler)
+	mux.Handle("/port_toptracks/stream", middleware.SpotifyAuthRequired(streamPlaylistHandler))
+
+	previewPlaylistHandler := http.HandlerFunc(playlist.PreviewTopTracksHandler)
+	mux.Handle("/port_toptracks/preview", middleware.SpotifyAuthRequired(previewPlaylistHandler))
+	commitPlaylistHandler := http.HandlerFunc(playlist.CommitTopTracksHandler)
+	mux.Handle("/port_toptracks/commit", middleware.SpotifyAuthRequired(commitPlaylistHandler))
+
+	// Scheduled playlist sync routes
+	createSyncHandler := http.HandlerFunc(playlist.CreateSyncHandler)
+	mux.Handle("/syncs", middleware.SpotifyAuthRequired(createSyncHandler))
+	syncHandler := http.HandlerFunc(playlist.SyncHandler)
+	mux.Handle("/syncs/", middleware.SpotifyAuthRequired(syncHandler))
+	playlist.StartScheduler()
+
 	// Chart routes
 	mux.HandleFunc("/chart", chart.PageHandler)
 	mux.HandleFunc("/generate_chart", chart.GenerateChartHandler)
+	mux.HandleFunc("/chart/cache_stats", chart.CacheStatsHandler)
+	mux.HandleFunc("/api/charts", chart.CreateJobHandler)
+	mux.HandleFunc("/api/charts/", chart.JobHandler)
 
 	// Spotify auth routes
 	mux.HandleFunc("/login", spotify.LoginHandler)
@@ -0,0 +1,196 @@
+// Package match finds the Spotify track that best matches a track from Last.fm
+package match
+
+import (
+	"sort"
+	"strings"
+
+	"github.com/conorbros/las-tools/spotify"
+)
+
+// Threshold is the lowest score a candidate can have and still be used as a match
+const Threshold = 0.7
+
+// Weights of each part of a candidate's score
+const (
+	artistWeight   = 0.4
+	titleWeight    = 0.4
+	durationWeight = 0.1
+	albumWeight    = 0.1
+)
+
+// durationTolerance is the difference in length, in seconds, that is still a perfect duration score.
+// Beyond it the score falls until durationLimit where it is zero.
+const (
+	durationTolerance = 3
+	durationLimit     = 30
+)
+
+// minSimilarity is the artist or title similarity below which the score is scaled down by the
+// similarity, as names that far apart are almost certainly different
+const minSimilarity = 0.6
+
+// versionPenalty scales the score for each way a candidate is a different recording than the
+// track, such as a live version of a studio track
+const versionPenalty = 0.5
+
+// albumTypeScores is how likely a track on each type of album is to be the original release
+var albumTypeScores = map[string]float64{
+	"album":       1,
+	"single":      1,
+	"compilation": 0.5,
+}
+
+// Match is a Spotify candidate for a track with its score
+type Match struct {
+	Candidate spotify.Candidate
+	Score     float64
+	// Strategy is the name of the search strategy that found the candidate
+	Strategy string
+}
+
+// Score scores how likely the candidate is to be the track, from 0 to 1. The artist and title are
+// compared after normalising them, and the duration and album type are used when they are known.
+func Score(track spotify.Track, c spotify.Candidate) float64 {
+	artist := artistSimilarity(track.Artist, c.Artists)
+	title := similarity(NormalizeTitle(track.Title), NormalizeTitle(c.Name))
+	score := artistWeight*artist + titleWeight*title
+	weight := artistWeight + titleWeight
+
+	if track.Duration > 0 && c.DurationMs > 0 {
+		score += durationWeight * durationScore(track.Duration, c.DurationMs/1000)
+		weight += durationWeight
+	}
+	if albumScore, ok := albumTypeScores[c.Album.AlbumType]; ok {
+		score += albumWeight * albumScore
+		weight += albumWeight
+	}
+	score /= weight
+
+	// A close duration can't make up for the wrong artist or song
+	for _, part := range []float64{artist, title} {
+		if part < minSimilarity {
+			score *= part
+		}
+	}
+
+	want, got := versionTags(track.Title), versionTags(c.Name)
+	for tag := range got {
+		if !want[tag] {
+			score *= versionPenalty
+		}
+	}
+	for tag := range want {
+		if !got[tag] {
+			score *= versionPenalty
+		}
+	}
+	return score
+}
+
+// Rank scores every candidate and orders them from best to worst. Candidates with the
+// same score keep Spotify's order.
+func Rank(track spotify.Track, candidates []spotify.Candidate) []Match {
+	matches := make([]Match, len(candidates))
+	for i, c := range candidates {
+		matches[i] = Match{Candidate: c, Score: Score(track, c)}
+	}
+	sort.SliceStable(matches, func(i, j int) bool {
+		return matches[i].Score > matches[j].Score
+	})
+	return matches
+}
+
+// Best gets the best candidate for the track. ok is false if there are no candidates or
+// the best one scores below the threshold.
+func Best(track spotify.Track, candidates []spotify.Candidate) (m Match, ok bool) {
+	matches := Rank(track, candidates)
+	if len(matches) == 0 || matches[0].Score < Threshold {
+		return Match{}, false
+	}
+	return matches[0], true
+}
+
+// artistSimilarity compares the Last.fm artist with the candidate's artists. Last.fm often credits
+// every artist in one name, as in "A & B", so the artists are also compared together and the
+// first artist of the credit is compared on its own.
+func artistSimilarity(artist string, artists []spotify.Artist) float64 {
+	want := []string{normalize(artist), normalize(primaryArtist(artist))}
+
+	var names []string
+	for _, a := range artists {
+		names = append(names, a.Name)
+	}
+	got := append(names, strings.Join(names, " and "))
+
+	var best float64
+	for _, w := range want {
+		for _, g := range got {
+			if s := similarity(w, normalize(g)); s > best {
+				best = s
+			}
+		}
+	}
+	return best
+}
+
+// durationScore compares two lengths in seconds
+func durationScore(want, got int) float64 {
+	diff := want - got
+	if diff < 0 {
+		diff = -diff
+	}
+	switch {
+	case diff <= durationTolerance:
+		return 1
+	case diff >= durationLimit:
+		return 0
+	}
+	return 1 - float64(diff-durationTolerance)/float64(durationLimit-durationTolerance)
+}
+
+// similarity is 1 for equal strings falling to 0 as more of the characters need to be changed
+// to turn one string into the other
+func similarity(a, b string) float64 {
+	ra, rb := []rune(a), []rune(b)
+	longest := len(ra)
+	if len(rb) > longest {
+		longest = len(rb)
+	}
+	if longest == 0 {
+		return 1
+	}
+	return 1 - float64(levenshtein(ra, rb))/float64(longest)
+}
+
+// levenshtein counts the insertions, deletions and substitutions needed to turn a into b
+func levenshtein(a, b []rune) int {
+	prev := make([]int, len(b)+1)
+	cur := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+
+	for i := 1; i <= len(a); i++ {
+		cur[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			cur[j] = min(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+		}
+		prev, cur = cur, prev
+	}
+	return prev[len(b)]
+}
+
+func min(values ...int) int {
+	m := values[0]
+	for _, v := range values[1:] {
+		if v < m {
+			m = v
+		}
+	}
+	return m
+}
@@ -0,0 +1,79 @@
+package match
+
+import (
+	"testing"
+
+	"github.com/conorbros/las-tools/spotify"
+)
+
+func TestNormalizeTitle(t *testing.T) {
+	tests := []struct {
+		title, want string
+	}{
+		{"Here Comes the Sun - Remastered 2009", "here comes the sun"},
+		{"Get Lucky (feat. Pharrell Williams)", "get lucky"},
+		{"Blinding Lights ft. Rosalía", "blinding lights"},
+		{"Hey Ya! [Radio Edit]", "hey ya"},
+		{"Jóga", "joga"},
+		{"Don't Stop Me Now", "dont stop me now"},
+		{"Rock & Roll", "rock and roll"},
+		{"Alive (Deluxe Version)", "alive"},
+		{"(Sittin' On) the Dock of the Bay", "sittin on the dock of the bay"},
+		{"Song 2 - Live at Glastonbury", "song 2"},
+	}
+	for _, test := range tests {
+		if got := NormalizeTitle(test.title); got != test.want {
+			t.Errorf("NormalizeTitle(%q) = %q; want %q", test.title, got, test.want)
+		}
+	}
+}
+
+func candidate(artist, name string, durationMs int, albumType string) spotify.Candidate {
+	return spotify.Candidate{
+		URI:        "spotify:track:" + name,
+		Name:       name,
+		Artists:    []spotify.Artist{{Name: artist}},
+		DurationMs: durationMs,
+		Album:      spotify.Album{AlbumType: albumType},
+	}
+}
+
+func TestBestPrefersStudioVersion(t *testing.T) {
+	track := spotify.Track{Artist: "Queen", Title: "Don't Stop Me Now", Duration: 209}
+	candidates := []spotify.Candidate{
+		candidate("Queen", "Don't Stop Me Now - Live At Wembley", 225000, "album"),
+		candidate("Karaoke Hits Band", "Don't Stop Me Now (Karaoke Version)", 210000, "compilation"),
+		candidate("Queen", "Don't Stop Me Now - Remastered 2011", 209000, "compilation"),
+		candidate("Queen", "Don't Stop Me Now - Remastered 2011", 209000, "album"),
+	}
+
+	m, ok := Best(track, candidates)
+	if !ok {
+		t.Fatal("Best() found no match")
+	}
+	if m.Candidate.Name != "Don't Stop Me Now - Remastered 2011" || m.Candidate.Album.AlbumType != "album" {
+		t.Errorf("Best() = %q on a %s; want the remaster on an album", m.Candidate.Name, m.Candidate.Album.AlbumType)
+	}
+	if m.Score < 0.95 {
+		t.Errorf("score = %.2f; want close to 1", m.Score)
+	}
+}
+
+func TestBestRejectsPoorMatches(t *testing.T) {
+	track := spotify.Track{Artist: "Death in Vegas", Title: "Girls"}
+	candidates := []spotify.Candidate{
+		candidate("Girls Aloud", "The Promise", 0, "single"),
+		candidate("Beastie Boys", "Girls", 0, "album"),
+	}
+
+	if m, ok := Best(track, candidates); ok {
+		t.Errorf("Best() = %q by %s with score %.2f; want no match", m.Candidate.Name, m.Candidate.Artists[0].Name, m.Score)
+	}
+}
+
+func TestArtistSimilarityCombinedCredit(t *testing.T) {
+	artists := []spotify.Artist{{Name: "Simon"}, {Name: "Garfunkel"}}
+	if s := artistSimilarity("Simon & Garfunkel", artists); s != 1 {
+		t.Errorf("artistSimilarity() = %.2f; want 1", s)
+	}
+}
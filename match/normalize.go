@@ -0,0 +1,151 @@
+package match
+
+import (
+	"regexp"
+	"strings"
+	"unicode"
+)
+
+// diacritics maps accented Latin letters to the letter without the accent
+var diacritics = map[rune]string{
+	'à': "a", 'á': "a", 'â': "a", 'ã': "a", 'ä': "a", 'å': "a", 'ā': "a", 'ă': "a", 'ą': "a",
+	'æ': "ae",
+	'ç': "c", 'ć': "c", 'č': "c",
+	'ď': "d", 'đ': "d", 'ð': "d",
+	'è': "e", 'é': "e", 'ê': "e", 'ë': "e", 'ē': "e", 'ė': "e", 'ę': "e", 'ě': "e",
+	'ğ': "g",
+	'ì': "i", 'í': "i", 'î': "i", 'ï': "i", 'ī': "i", 'į': "i", 'ı': "i",
+	'ł': "l", 'ľ': "l",
+	'ñ': "n", 'ń': "n", 'ň': "n",
+	'ò': "o", 'ó': "o", 'ô': "o", 'õ': "o", 'ö': "o", 'ø': "o", 'ō': "o", 'ő': "o",
+	'œ': "oe",
+	'ř': "r",
+	'ś': "s", 'š': "s", 'ş': "s", 'ß': "ss",
+	'ť': "t", 'ţ': "t", 'þ': "th",
+	'ù': "u", 'ú': "u", 'û': "u", 'ü': "u", 'ū': "u", 'ů': "u", 'ű': "u", 'ų': "u",
+	'ý': "y", 'ÿ': "y",
+	'ź': "z", 'ż': "z", 'ž': "z",
+}
+
+// versionWords mark a bracketed or dashed part of a title as describing the version of the
+// track rather than being part of its name
+var versionWords = []string{"remaster", "live", "edit", "version", "mono", "stereo", "deluxe", "bonus", "single", "mix", "remix", "acoustic", "demo", "instrumental", "karaoke", "unplugged"}
+
+var (
+	// featuring matches a featured artist credit and everything after it
+	featuring = regexp.MustCompile(`(?i)[\(\[]?\s*\b(feat|ft|featuring)\b\.?.*$`)
+	// bracketed matches a part of a title in brackets
+	bracketed = regexp.MustCompile(`\s*[\(\[][^\)\]]*[\)\]]`)
+	// dashed matches a part of a title after " - ", as in "Song - Remastered 2011"
+	dashed = regexp.MustCompile(`\s+-\s+.*$`)
+)
+
+// foldDiacritics lowercases s and removes the accents from its letters
+func foldDiacritics(s string) string {
+	var b strings.Builder
+	for _, r := range strings.ToLower(s) {
+		if plain, ok := diacritics[r]; ok {
+			b.WriteString(plain)
+		} else {
+			b.WriteRune(r)
+		}
+	}
+	return b.String()
+}
+
+// cyrillic maps Cyrillic letters to their Latin transliteration
+var cyrillic = map[rune]string{
+	'а': "a", 'б': "b", 'в': "v", 'г': "g", 'д': "d", 'е': "e", 'ё': "e", 'ж': "zh", 'з': "z",
+	'и': "i", 'й': "y", 'к': "k", 'л': "l", 'м': "m", 'н': "n", 'о': "o", 'п': "p", 'р': "r",
+	'с': "s", 'т': "t", 'у': "u", 'ф': "f", 'х': "kh", 'ц': "ts", 'ч': "ch", 'ш': "sh", 'щ': "shch",
+	'ъ': "", 'ы': "y", 'ь': "", 'э': "e", 'ю': "yu", 'я': "ya", 'і': "i", 'ї': "yi", 'є': "ye", 'ґ': "g",
+}
+
+// transliterate lowercases s and writes it with plain Latin letters, removing accents and
+// transliterating Cyrillic
+func transliterate(s string) string {
+	var b strings.Builder
+	for _, r := range foldDiacritics(s) {
+		if latin, ok := cyrillic[r]; ok {
+			b.WriteString(latin)
+		} else {
+			b.WriteRune(r)
+		}
+	}
+	return b.String()
+}
+
+// isVersion reports whether a part of a title describes the version of the track. Words are
+// matched by prefix so "remastered" counts as "remaster".
+func isVersion(part string) bool {
+	for _, w := range strings.Fields(normalize(part)) {
+		for _, v := range versionWords {
+			if strings.HasPrefix(w, v) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// stripVersion removes the featured artists and the bracketed or dashed parts of a title that
+// describe its version, so "Song (feat. X) - Remastered 2011" becomes "Song"
+func stripVersion(title string) string {
+	title = featuring.ReplaceAllString(title, "")
+	title = bracketed.ReplaceAllStringFunc(title, func(part string) string {
+		if isVersion(part) {
+			return ""
+		}
+		return part
+	})
+	title = dashed.ReplaceAllStringFunc(title, func(part string) string {
+		if isVersion(part) {
+			return ""
+		}
+		return part
+	})
+	return title
+}
+
+// normalize makes a name comparable with the same name written differently. The case, accents and
+// punctuation are removed and "&" is treated as "and".
+func normalize(s string) string {
+	s = foldDiacritics(s)
+	s = strings.ReplaceAll(s, "&", " and ")
+
+	var b strings.Builder
+	for _, r := range s {
+		switch {
+		case unicode.IsLetter(r) || unicode.IsDigit(r):
+			b.WriteRune(r)
+		case r == '\'' || r == '’':
+			// Drop apostrophes so "don't" and "dont" are the same
+		default:
+			b.WriteRune(' ')
+		}
+	}
+	return strings.Join(strings.Fields(b.String()), " ")
+}
+
+// NormalizeTitle normalises a track title for comparison, removing featured artists and
+// remaster, live and edit suffixes as well as case, accents and punctuation
+func NormalizeTitle(title string) string {
+	return normalize(stripVersion(title))
+}
+
+// otherRecordings are words in a title that mean it is a different recording of the song
+var otherRecordings = []string{"live", "acoustic", "demo", "instrumental", "karaoke", "remix", "unplugged"}
+
+// versionTags gets the words of a title that mean it is a different recording of the song than
+// the studio version. Remasters and edits are left out as they are the same recording.
+func versionTags(title string) map[string]bool {
+	tags := make(map[string]bool)
+	for _, w := range strings.Fields(normalize(title)) {
+		for _, v := range otherRecordings {
+			if w == v {
+				tags[v] = true
+			}
+		}
+	}
+	return tags
+}
@@ -0,0 +1,131 @@
+package match
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/conorbros/las-tools/spotify"
+)
+
+// Names of the search strategies, reported with each match so the cascade can be tuned
+const (
+	StrategyStrict          = "strict"
+	StrategyFreeText        = "free-text"
+	StrategyNoParenthetical = "no-parenthetical"
+	StrategyPrimaryArtist   = "primary-artist"
+	StrategyTransliterated  = "transliterated"
+)
+
+// strategy builds a Spotify search query for a track. ok is false when the strategy
+// can't do anything different for the track, such as when there is only one artist.
+type strategy struct {
+	name  string
+	query func(track spotify.Track) (q string, ok bool)
+}
+
+// strategies are tried in order until one finds a confident match
+var strategies = []strategy{
+	{StrategyStrict, strictQuery},
+	{StrategyFreeText, freeTextQuery},
+	{StrategyNoParenthetical, noParentheticalQuery},
+	{StrategyPrimaryArtist, primaryArtistQuery},
+	{StrategyTransliterated, transliteratedQuery},
+}
+
+// separators split a credit of several artists such as "A & B" or "A feat. B"
+var separators = regexp.MustCompile(`(?i)\s*(?:&|,|\+|\s/\s|\sand\s|\sx\s|\swith\s|\s(?:feat|ft|featuring)\b\.?)\s*`)
+
+// anyBracketed matches every bracketed or dashed part of a title, not only those describing the version
+var anyBracketed = regexp.MustCompile(`\s*[\(\[][^\)\]]*[\)\]]|\s+-\s+.*$`)
+
+func strictQuery(track spotify.Track) (string, bool) {
+	return fmt.Sprintf("artist:%s track:%s", track.Artist, track.Title), true
+}
+
+func freeTextQuery(track spotify.Track) (string, bool) {
+	return track.Artist + " " + track.Title, true
+}
+
+func noParentheticalQuery(track spotify.Track) (string, bool) {
+	title := strings.TrimSpace(anyBracketed.ReplaceAllString(track.Title, ""))
+	if title == "" || title == track.Title {
+		return "", false
+	}
+	return fmt.Sprintf("artist:%s track:%s", track.Artist, title), true
+}
+
+func primaryArtistQuery(track spotify.Track) (string, bool) {
+	artist := primaryArtist(track.Artist)
+	if artist == track.Artist {
+		return "", false
+	}
+	return fmt.Sprintf("artist:%s track:%s", artist, track.Title), true
+}
+
+func transliteratedQuery(track spotify.Track) (string, bool) {
+	artist, title := transliterate(track.Artist), transliterate(track.Title)
+	if artist == strings.ToLower(track.Artist) && title == strings.ToLower(track.Title) {
+		return "", false
+	}
+	return fmt.Sprintf("artist:%s track:%s", artist, title), true
+}
+
+// primaryArtist gets the first artist of a credit of several artists
+func primaryArtist(artist string) string {
+	primary := strings.TrimSpace(separators.Split(artist, 2)[0])
+	if primary == "" {
+		return artist
+	}
+	return primary
+}
+
+// FindTrack searches Spotify for the track and gets the best match. When a search finds no
+// confident match the next strategy is tried, and the strategy that found the match is returned
+// with it. ok is false if no strategy found a confident match.
+func FindTrack(track spotify.Track, clientAccessToken string) (m Match, ok bool, err error) {
+	matches, ok, err := FindCandidates(track, clientAccessToken)
+	if err != nil || !ok {
+		return Match{}, false, err
+	}
+	return matches[0], true, nil
+}
+
+// FindCandidates searches Spotify for the track with each strategy in turn until one finds a
+// confident match. Every candidate found along the way is returned ranked from best to worst,
+// each with the strategy that first found it. ok is false if none of them is a confident match.
+func FindCandidates(track spotify.Track, clientAccessToken string) (matches []Match, ok bool, err error) {
+	tried := make(map[string]bool)
+	found := make(map[string]bool)
+	for _, s := range strategies {
+		q, apply := s.query(track)
+		if !apply || tried[q] {
+			continue
+		}
+		tried[q] = true
+
+		candidates, err := spotify.SearchTracksQuery(q, clientAccessToken)
+		if err != nil {
+			return nil, false, err
+		}
+
+		for _, m := range Rank(track, candidates) {
+			if found[m.Candidate.URI] {
+				continue
+			}
+			found[m.Candidate.URI] = true
+			m.Strategy = s.name
+			matches = append(matches, m)
+			ok = ok || m.Score >= Threshold
+		}
+		if ok {
+			break
+		}
+	}
+
+	sort.SliceStable(matches, func(i, j int) bool {
+		return matches[i].Score > matches[j].Score
+	})
+	return matches, ok, nil
+}
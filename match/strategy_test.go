@@ -0,0 +1,68 @@
+package match
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/conorbros/las-tools/conf"
+	"github.com/conorbros/las-tools/spotify"
+)
+
+func TestStrategyQueries(t *testing.T) {
+	tests := []struct {
+		strategy strategy
+		track    spotify.Track
+		want     string
+	}{
+		{strategy{query: noParentheticalQuery}, spotify.Track{Artist: "Blur", Title: "Song 2 (Kids Choir)"}, "artist:Blur track:Song 2"},
+		{strategy{query: primaryArtistQuery}, spotify.Track{Artist: "Simon & Garfunkel", Title: "America"}, "artist:Simon track:America"},
+		{strategy{query: primaryArtistQuery}, spotify.Track{Artist: "Calvin Harris feat. Rihanna", Title: "This Is What You Came For"}, "artist:Calvin Harris track:This Is What You Came For"},
+		{strategy{query: transliteratedQuery}, spotify.Track{Artist: "Кино", Title: "Группа крови"}, "artist:kino track:gruppa krovi"},
+		{strategy{query: transliteratedQuery}, spotify.Track{Artist: "Sigur Rós", Title: "Hoppípolla"}, "artist:sigur ros track:hoppipolla"},
+	}
+	for _, test := range tests {
+		got, ok := test.strategy.query(test.track)
+		if !ok || got != test.want {
+			t.Errorf("query(%s - %s) = %q, %v; want %q", test.track.Artist, test.track.Title, got, ok, test.want)
+		}
+	}
+
+	// Strategies that can't change the query are skipped
+	track := spotify.Track{Artist: "Blur", Title: "Song 2"}
+	for _, query := range []func(spotify.Track) (string, bool){noParentheticalQuery, primaryArtistQuery, transliteratedQuery} {
+		if q, ok := query(track); ok {
+			t.Errorf("query = %q; want the strategy skipped", q)
+		}
+	}
+}
+
+func TestFindTrackFallsBack(t *testing.T) {
+	var queries []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		q := r.URL.Query().Get("q")
+		queries = append(queries, q)
+		if q != "Simon & Garfunkel The Boxer" {
+			fmt.Fprint(w, `{"tracks":{"items":[]}}`)
+			return
+		}
+		fmt.Fprint(w, `{"tracks":{"items":[{"uri":"spotify:track:boxer","name":"The Boxer","artists":[{"name":"Simon & Garfunkel"}],"album":{"album_type":"album"}}]}}`)
+	}))
+	defer server.Close()
+	conf.Config.Spotify.SearchEndpoint = server.URL
+
+	m, ok, err := FindTrack(spotify.Track{Artist: "Simon & Garfunkel", Title: "The Boxer"}, "Bearer token")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !ok {
+		t.Fatalf("FindTrack() found no match after %q", queries)
+	}
+	if m.Strategy != StrategyFreeText || m.Candidate.URI != "spotify:track:boxer" {
+		t.Errorf("FindTrack() = %s with %s; want spotify:track:boxer with %s", m.Candidate.URI, m.Strategy, StrategyFreeText)
+	}
+	if len(queries) != 2 {
+		t.Errorf("made %d searches; want 2", len(queries))
+	}
+}
@@ -0,0 +1,212 @@
+package playlist
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/conorbros/las-tools/spotify"
+)
+
+// defaultNameTemplates name playlists of tracks from each source when the request doesn't give a name
+var defaultNameTemplates = map[string]string{
+	sourceTop:    "{user}'s top {n} – {period} – {date}",
+	sourceLoved:  "{user}'s loved tracks – {date}",
+	sourceRecent: "{user}'s listening – {period}",
+}
+
+// defaultDescription describes playlists when the request doesn't give a description
+const defaultDescription = "This playlist was generated automatically with conorb.dev lastools"
+
+// Limits Spotify puts on playlist details
+const (
+	maxNameLength        = 100
+	maxDescriptionLength = 300
+)
+
+// periodNames are how each Last.fm period is written in playlist names
+var periodNames = map[string]string{
+	"7day":    "last 7 days",
+	"1month":  "last month",
+	"3month":  "last 3 months",
+	"6month":  "last 6 months",
+	"12month": "last year",
+	"overall": "all time",
+}
+
+// placeholder matches a {placeholder} in a name or description template
+var placeholder = regexp.MustCompile(`\{[^{}]*\}`)
+
+// Where ported tracks can go
+const (
+	destinationPlaylist = "playlist"
+	destinationLiked    = "liked"
+)
+
+// playlistOptions are the settings for the playlist a port creates. The name and description
+// are templates that can use {user}, {n}, {period} and {date}.
+type playlistOptions struct {
+	// Destination is where the tracks go, a playlist or the user's Liked Songs. It is a
+	// playlist when the request doesn't say.
+	Destination         string
+	PlaylistName        string
+	PlaylistDescription string
+	// Public is nil when the request doesn't say, in which case the playlist is public unless
+	// it is collaborative
+	Public        *bool
+	Collaborative bool
+	// TargetPlaylistID is the ID, URI or link of a playlist to replace the contents of
+	// instead of creating a new one
+	TargetPlaylistID string
+	// TargetPlaylistName is the name of a playlist to replace the contents of. A playlist
+	// with the name is created if the user doesn't have one.
+	TargetPlaylistName string
+}
+
+// playlistID matches a Spotify playlist ID on its own or in a playlist URI or link
+var playlistID = regexp.MustCompile(`^(?:spotify:playlist:|https://open\.spotify\.com/playlist/)?([A-Za-z0-9]+)(?:\?.*)?$`)
+
+// targetID gets the ID of the playlist to replace from TargetPlaylistID, which can be an ID,
+// URI or link. It is empty if there isn't one.
+func (o playlistOptions) targetID() (string, error) {
+	target := strings.TrimSpace(o.TargetPlaylistID)
+	if target == "" {
+		return "", nil
+	}
+	match := playlistID.FindStringSubmatch(target)
+	if match == nil {
+		return "", errors.New("The playlist to update isn't a valid Spotify playlist ID or link")
+	}
+	return match[1], nil
+}
+
+// liked reports whether the tracks go to the user's Liked Songs instead of a playlist
+func (o playlistOptions) liked() bool {
+	return o.Destination == destinationLiked
+}
+
+// details fills in the templates and defaults of the options for a playlist of n tracks from
+// the source, returning an error with a message for the user if the options aren't valid.
+// period is how the time period is written in the playlist name.
+func (o playlistOptions) details(user string, n int, source string, period string, now time.Time) (spotify.PlaylistDetails, error) {
+	var d spotify.PlaylistDetails
+
+	switch o.Destination {
+	case "", destinationPlaylist:
+	case destinationLiked:
+		if o.TargetPlaylistID != "" || o.TargetPlaylistName != "" {
+			return d, errors.New("Tracks saved to Liked Songs can't also update a playlist")
+		}
+	default:
+		return d, errors.New("Choose whether to save the tracks to a playlist or Liked Songs")
+	}
+
+	values := map[string]string{
+		"{user}":   user,
+		"{n}":      strconv.Itoa(n),
+		"{period}": period,
+		"{date}":   now.Format("2006-01-02"),
+	}
+
+	nameTemplate := strings.TrimSpace(o.PlaylistName)
+	if nameTemplate == "" {
+		nameTemplate = defaultNameTemplates[source]
+	}
+	if nameTemplate == "" {
+		nameTemplate = defaultNameTemplates[sourceTop]
+	}
+	name, err := expandTemplate(nameTemplate, values)
+	if err != nil {
+		return d, err
+	}
+	if name == "" {
+		return d, errors.New("The playlist name can't be empty")
+	}
+	if utf8.RuneCountInString(name) > maxNameLength {
+		return d, fmt.Errorf("The playlist name can be at most %d characters", maxNameLength)
+	}
+
+	descriptionTemplate := strings.TrimSpace(o.PlaylistDescription)
+	if descriptionTemplate == "" {
+		descriptionTemplate = defaultDescription
+	}
+	description, err := expandTemplate(descriptionTemplate, values)
+	if err != nil {
+		return d, err
+	}
+	// Spotify rejects descriptions with line breaks
+	description = strings.Join(strings.Fields(description), " ")
+	if utf8.RuneCountInString(description) > maxDescriptionLength {
+		return d, fmt.Errorf("The playlist description can be at most %d characters", maxDescriptionLength)
+	}
+
+	public := !o.Collaborative
+	if o.Public != nil {
+		public = *o.Public
+	}
+	if public && o.Collaborative {
+		return d, errors.New("A collaborative playlist can't be public")
+	}
+
+	if o.TargetPlaylistID != "" && o.TargetPlaylistName != "" {
+		return d, errors.New("Choose the playlist to update by its ID or its name, not both")
+	}
+	if _, err := o.targetID(); err != nil {
+		return d, err
+	}
+	if utf8.RuneCountInString(o.TargetPlaylistName) > maxNameLength {
+		return d, fmt.Errorf("The playlist name can be at most %d characters", maxNameLength)
+	}
+
+	return spotify.PlaylistDetails{
+		Name:          name,
+		Description:   description,
+		Public:        public,
+		Collaborative: o.Collaborative,
+	}, nil
+}
+
+// expandTemplate replaces the placeholders in the template with their values. Unknown
+// placeholders are an error so typos don't end up in the playlist name.
+func expandTemplate(template string, values map[string]string) (string, error) {
+	var err error
+	expanded := placeholder.ReplaceAllStringFunc(template, func(p string) string {
+		value, ok := values[p]
+		if !ok && err == nil {
+			err = fmt.Errorf("Unknown placeholder %s. Use {user}, {n}, {period} or {date}", p)
+		}
+		return value
+	})
+	return strings.TrimSpace(expanded), err
+}
+
+// targetPlaylist gets the playlist the tracks go in. When the options name an existing playlist
+// it is returned with replace set so its contents are replaced. Otherwise a new playlist is created
+// with the details, named after TargetPlaylistName if that playlist wasn't found.
+func (o playlistOptions) targetPlaylist(userID string, details spotify.PlaylistDetails, authDetails *spotify.AuthDetails) (playlist spotify.Playlist, replace bool, err error) {
+	id, err := o.targetID()
+	if err != nil {
+		return spotify.Playlist{}, false, err
+	}
+	if id != "" {
+		return spotify.Playlist{ID: id}, true, nil
+	}
+
+	if name := strings.TrimSpace(o.TargetPlaylistName); name != "" {
+		playlist, ok, err := spotify.FindPlaylistByName(userID, name, authDetails)
+		if err != nil {
+			return spotify.Playlist{}, false, err
+		}
+		if ok {
+			return playlist, true, nil
+		}
+		details.Name = name
+	}
+
+	playlist, err = spotify.CreatePlaylist(userID, details, authDetails)
+	return playlist, false, err
+}
@@ -0,0 +1,70 @@
+package playlist
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestPlaylistOptionsDetails(t *testing.T) {
+	now := time.Date(2020, 10, 3, 12, 0, 0, 0, time.UTC)
+
+	d, err := playlistOptions{}.details("rj", 50, sourceTop, "last month", now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "rj's top 50 – last month – 2020-10-03"; d.Name != want {
+		t.Errorf("default name = %q; want %q", d.Name, want)
+	}
+	if d.Description != defaultDescription || !d.Public || d.Collaborative {
+		t.Errorf("default details = %+v; want a public playlist with the default description", d)
+	}
+
+	private := false
+	d, err = playlistOptions{
+		PlaylistName:        "  Top {n} ",
+		PlaylistDescription: "Made for {user}\non {date}",
+		Public:              &private,
+	}.details("rj", 10, sourceTop, "all time", now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d.Name != "Top 10" || d.Description != "Made for rj on 2020-10-03" || d.Public {
+		t.Errorf("details = %+v", d)
+	}
+
+	// Collaborative playlists are private unless asked otherwise, which Spotify doesn't allow
+	if d, err = (playlistOptions{Collaborative: true}).details("rj", 10, sourceTop, "all time", now); err != nil || d.Public {
+		t.Errorf("collaborative details = %+v, %v; want a private playlist", d, err)
+	}
+	public := true
+	if _, err = (playlistOptions{Collaborative: true, Public: &public}).details("rj", 10, sourceTop, "all time", now); err == nil {
+		t.Error("a public collaborative playlist was allowed")
+	}
+
+	invalid := []playlistOptions{
+		{PlaylistName: "{unknown}"},
+		{PlaylistName: strings.Repeat("a", maxNameLength+1)},
+		{PlaylistDescription: strings.Repeat("a", maxDescriptionLength+1)},
+		{TargetPlaylistID: "37i9dQZF1DXcBWIGoYBM5M", TargetPlaylistName: "Top Tracks"},
+		{TargetPlaylistID: "not an id!"},
+	}
+	for _, o := range invalid {
+		if _, err := o.details("rj", 10, sourceTop, "all time", now); err == nil {
+			t.Errorf("details(%+v) was allowed", o)
+		}
+	}
+}
+
+func TestPlaylistOptionsTargetID(t *testing.T) {
+	for _, target := range []string{
+		"37i9dQZF1DXcBWIGoYBM5M",
+		"spotify:playlist:37i9dQZF1DXcBWIGoYBM5M",
+		"https://open.spotify.com/playlist/37i9dQZF1DXcBWIGoYBM5M?si=abc",
+	} {
+		id, err := playlistOptions{TargetPlaylistID: target}.targetID()
+		if err != nil || id != "37i9dQZF1DXcBWIGoYBM5M" {
+			t.Errorf("targetID(%q) = %q, %v", target, id, err)
+		}
+	}
+}
@@ -0,0 +1,65 @@
+package playlist
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+)
+
+// Events sent while porting a playlist
+const (
+	eventSearching       = "searching"
+	eventMatched         = "matched"
+	eventNotFound        = "not-found"
+	eventPlaylistCreated = "playlist-created"
+	eventPlaylistFound   = "playlist-found"
+	eventTracksAdded     = "tracks-added"
+	eventError           = "error"
+)
+
+// eventStream writes Server-Sent Events to a response, flushing after each one so the
+// client sees them straight away. It is safe to send events from several goroutines.
+type eventStream struct {
+	mu      sync.Mutex
+	w       http.ResponseWriter
+	flusher http.Flusher
+}
+
+// newEventStream starts an event stream on the response
+func newEventStream(w http.ResponseWriter) (*eventStream, error) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		return nil, errors.New("Streaming is not supported by the response writer")
+	}
+
+	w.Header().Set("Content-type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	return &eventStream{w: w, flusher: flusher}, nil
+}
+
+// send sends an event with data encoded as JSON
+func (s *eventStream) send(event string, data interface{}) error {
+	jsonValue, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, err := fmt.Fprintf(s.w, "event: %s\ndata: %s\n\n", event, jsonValue); err != nil {
+		return err
+	}
+	s.flusher.Flush()
+	return nil
+}
+
+// sendError sends an error event with a message for the user
+func (s *eventStream) sendError(msg string) {
+	s.send(eventError, map[string]string{"message": msg})
+}
@@ -0,0 +1,129 @@
+package playlist
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/conorbros/las-tools/conf"
+	"github.com/conorbros/las-tools/middleware"
+	"github.com/conorbros/las-tools/util"
+)
+
+// sseEvent is an event read back from a Server-Sent Events response
+type sseEvent struct {
+	name string
+	data string
+}
+
+// readEvents splits a Server-Sent Events response into its events
+func readEvents(t *testing.T, body string) []sseEvent {
+	var events []sseEvent
+	for _, block := range strings.Split(strings.TrimSpace(body), "\n\n") {
+		var e sseEvent
+		for _, line := range strings.Split(block, "\n") {
+			switch {
+			case strings.HasPrefix(line, "event: "):
+				e.name = strings.TrimPrefix(line, "event: ")
+			case strings.HasPrefix(line, "data: "):
+				e.data = strings.TrimPrefix(line, "data: ")
+			default:
+				t.Fatalf("unexpected line %q in event stream", line)
+			}
+		}
+		events = append(events, e)
+	}
+	return events
+}
+
+func TestPortTopTracksStreamHandlerEvents(t *testing.T) {
+	lastFm, _ := tracksServer("toptracks", 2)
+	defer lastFm.Close()
+	conf.Config.LastFm.UserTopTracksEndpoint = lastFm.URL + "/?method=user.gettoptracks"
+
+	// Only Track 0 is on Spotify
+	var added []string
+	spotifyAPI := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.URL.Path == "/token":
+			fmt.Fprint(w, `{"access_token":"token","expires_in":3600}`)
+		case r.URL.Path == "/search":
+			if strings.Contains(r.URL.Query().Get("q"), "Track 0") {
+				fmt.Fprint(w, `{"tracks":{"items":[{"uri":"spotify:track:0","name":"Track 0","artists":[{"name":"Artist"}],"duration_ms":180000}]}}`)
+				return
+			}
+			fmt.Fprint(w, `{"tracks":{"items":[]}}`)
+		case r.URL.Path == "/me":
+			fmt.Fprint(w, `{"id":"owner"}`)
+		case r.URL.Path == "/users/owner/playlists":
+			w.WriteHeader(http.StatusCreated)
+			fmt.Fprint(w, `{"id":"p"}`)
+		case r.URL.Path == "/playlists/p/tracks":
+			var body map[string][]string
+			json.NewDecoder(r.Body).Decode(&body)
+			added = append(added, body["uris"]...)
+			w.WriteHeader(http.StatusCreated)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer spotifyAPI.Close()
+	conf.Config.Spotify.TokenEndpoint = spotifyAPI.URL + "/token"
+	conf.Config.Spotify.SearchEndpoint = spotifyAPI.URL + "/search"
+	conf.Config.Spotify.UserInfoEndpoint = spotifyAPI.URL + "/me"
+	conf.Config.Spotify.UserPlaylistEndpoint = spotifyAPI.URL + "/users/{user_id}/playlists"
+	conf.Config.Spotify.AddItemsPlaylistEndpoint = spotifyAPI.URL + "/playlists/{playlist_id}/tracks"
+
+	body := fmt.Sprintf(`{"access_token":"user","expires_in":3600,"time_obtained":%d,"lastFmUsername":"stream","songNumber":"2","timePeriod":"7day"}`, util.EpochUTC())
+	w := httptest.NewRecorder()
+	middleware.SpotifyAuthRequired(http.HandlerFunc(PortTopTracksStreamHandler)).ServeHTTP(w, httptest.NewRequest("POST", "/port_toptracks/stream", strings.NewReader(body)))
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d: %s", w.Code, w.Body)
+	}
+	if got := w.Header().Get("Content-Type"); got != "text/event-stream" {
+		t.Errorf("Content-Type = %q; want text/event-stream", got)
+	}
+
+	events := readEvents(t, w.Body.String())
+	if len(events) != 6 {
+		t.Fatalf("got %d events %+v; want 6", len(events), events)
+	}
+
+	// The tracks are searched for concurrently so only the order of each track's events is fixed
+	outcomes := map[int]string{}
+	for _, e := range events[:4] {
+		var te trackEvent
+		if err := json.Unmarshal([]byte(e.data), &te); err != nil {
+			t.Fatal(err)
+		}
+		if te.Total != 2 {
+			t.Errorf("%s event total = %d; want 2", e.name, te.Total)
+		}
+		switch e.name {
+		case eventSearching:
+			if _, ok := outcomes[te.Index]; ok {
+				t.Errorf("track %d was searched for after its outcome", te.Index)
+			}
+		case eventMatched, eventNotFound:
+			outcomes[te.Index] = e.name
+		default:
+			t.Errorf("got a %s event before every track was searched for", e.name)
+		}
+	}
+	if outcomes[0] != eventMatched || outcomes[1] != eventNotFound {
+		t.Errorf("track outcomes = %v; want track 0 matched and track 1 not found", outcomes)
+	}
+
+	if events[4].name != eventPlaylistCreated || !strings.Contains(events[4].data, `"p"`) {
+		t.Errorf("event 5 = %+v; want playlist-created for the new playlist", events[4])
+	}
+	if events[5].name != eventTracksAdded {
+		t.Errorf("last event = %+v; want tracks-added", events[5])
+	}
+	if len(added) != 1 || added[0] != "spotify:track:0" {
+		t.Errorf("added %v; want the matched track", added)
+	}
+}
@@ -2,22 +2,111 @@ package playlist
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"html/template"
-	"io/ioutil"
+	"log"
 	"net/http"
-	"strings"
+	"net/url"
+	"strconv"
+	"sync"
 	"time"
 
 	"github.com/conorbros/las-tools/conf"
+	"github.com/conorbros/las-tools/lastfm"
+	"github.com/conorbros/las-tools/match"
 	"github.com/conorbros/las-tools/middleware"
 	"github.com/conorbros/las-tools/spotify"
 )
 
+// lastFmPageSize is the number of top tracks asked for in each request to Last.fm
+const lastFmPageSize = 200
+
+// maxSongNumber is the most tracks that can be ported at once
+const maxSongNumber = 1000
+
+// maxLovedSongNumber is the most loved tracks that can be ported at once. Loved tracks are
+// usually saved to Liked Songs, which has no limit, so it is higher than maxSongNumber.
+const maxLovedSongNumber = 10000
+
+// Where ported tracks can come from on Last.fm
+const (
+	sourceTop    = "top"
+	sourceLoved  = "loved"
+	sourceRecent = "recent"
+)
+
 type portPlaylistData struct {
 	LastFmUsername string
-	SongNumber     string
-	TimePeriod     string
+	// Source is the Last.fm tracks to port, the user's top tracks, loved tracks or recent
+	// scrobbles. It is their top tracks when the request doesn't say.
+	Source string
+	// SongNumber is the number of tracks to port. Every loved track, or up to maxSongNumber
+	// recent tracks, are ported when it is empty.
+	SongNumber string
+	// TimePeriod is the period of the user's top tracks. Loved tracks don't have one.
+	TimePeriod string
+	recentOptions
+	playlistOptions
+}
+
+// source gets the Last.fm tracks to port
+func (p portPlaylistData) source() string {
+	if p.Source == "" {
+		return sourceTop
+	}
+	return p.Source
+}
+
+// songCount gets the number of tracks to port
+func (p portPlaylistData) songCount() (int, error) {
+	if p.SongNumber == "" {
+		switch p.source() {
+		case sourceLoved:
+			return maxLovedSongNumber, nil
+		case sourceRecent:
+			return maxSongNumber, nil
+		}
+	}
+	return strconv.Atoi(p.SongNumber)
+}
+
+// periodName gets how the port's time period is written in playlist names
+func (p portPlaylistData) periodName() string {
+	if p.source() == sourceRecent {
+		return p.recentOptions.periodName()
+	}
+	return periodNames[p.TimePeriod]
+}
+
+// validate checks the port request, returning an error with a message for the user if it isn't valid
+func (p portPlaylistData) validate() error {
+	if p.LastFmUsername == "" {
+		return errors.New("Enter a Last.fm username")
+	}
+
+	max := maxSongNumber
+	switch p.source() {
+	case sourceTop:
+		if _, ok := periodNames[p.TimePeriod]; !ok {
+			return errors.New("Choose a time period")
+		}
+	case sourceLoved:
+		max = maxLovedSongNumber
+	case sourceRecent:
+		if err := p.recentOptions.validate(); err != nil {
+			return err
+		}
+	default:
+		return errors.New("Choose whether to port top tracks, loved tracks or recent tracks")
+	}
+
+	n, err := p.songCount()
+	if err != nil || n < 1 || n > max {
+		return fmt.Errorf("The number of songs must be between 1 and %d", max)
+	}
+	_, err = p.details(p.LastFmUsername, n, p.source(), p.periodName(), time.Now())
+	return err
 }
 
 // LastFmUserTopTracks represents the results retrieved from the LastFm API user top tracks
@@ -27,11 +116,30 @@ type LastFmUserTopTracks struct {
 			Artist struct {
 				Name string `json:"name"`
 			} `json:"artist"`
-			Name string `json:"name"`
+			Name     string `json:"name"`
+			Duration string `json:"duration"`
 		} `json:"track"`
+		Attr struct {
+			TotalPages string `json:"totalPages"`
+		} `json:"@attr"`
 	} `json:"toptracks"`
 }
 
+// LastFmUserLovedTracks represents the results retrieved from the LastFm API user loved tracks
+type LastFmUserLovedTracks struct {
+	Lovedtracks struct {
+		Tracks []struct {
+			Artist struct {
+				Name string `json:"name"`
+			} `json:"artist"`
+			Name string `json:"name"`
+		} `json:"track"`
+		Attr struct {
+			TotalPages string `json:"totalPages"`
+		} `json:"@attr"`
+	} `json:"lovedtracks"`
+}
+
 // PageHandler gets a user's top tracks from Last.fm and converts them into a Spotify playlist
 func PageHandler(w http.ResponseWriter, r *http.Request) {
 	if r.Method != "GET" {
@@ -43,7 +151,8 @@ func PageHandler(w http.ResponseWriter, r *http.Request) {
 	tpl.Execute(w, nil)
 }
 
-// PortTopTracksHandler gets a users top track from Last.fm and ports them to a spotify playlist
+// PortTopTracksHandler gets a users top, loved or recent tracks from Last.fm and ports them to a spotify
+// playlist or their Liked Songs
 func PortTopTracksHandler(w http.ResponseWriter, r *http.Request) {
 	if r.Method != "POST" {
 		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
@@ -57,9 +166,18 @@ func PortTopTracksHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	topTracks, err := getTopTracksLastFm(portData)
+	if err = portData.validate(); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	topTracks, err := getTracksLastFm(portData)
+	if lastfm.IsInvalidParameters(err) {
+		http.Error(w, "No songs found on Last.fm. Check the username", http.StatusBadRequest)
+		return
+	}
 	if err != nil {
-		http.Error(w, "Could not get top tracks data from LastFm", http.StatusInternalServerError)
+		http.Error(w, "Could not get tracks data from LastFm", http.StatusInternalServerError)
 		return
 	}
 
@@ -69,7 +187,7 @@ func PortTopTracksHandler(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Get the track's Spotify URIs
-	err = getTracksSpotifyURIs(topTracks)
+	err = getTracksSpotifyURIs(topTracks, nil)
 	if err != nil {
 		http.Error(w, "Could not get Spotify URIs for tracks", http.StatusInternalServerError)
 		return
@@ -77,90 +195,367 @@ func PortTopTracksHandler(w http.ResponseWriter, r *http.Request) {
 
 	spotifyAuthDetails := r.Context().Value(middleware.AuthCxtKey).(spotify.AuthDetails)
 
-	// Get User Info
-	userID, err := spotify.GetUserID(&spotifyAuthDetails)
+	var result addResult
+	if portData.liked() {
+		// Save tracks to Liked Songs
+		result, err = saveLikedTracks(topTracks, &spotifyAuthDetails)
+		if err != nil {
+			http.Error(w, "Could not save the tracks to your Liked Songs on spotify", http.StatusInternalServerError)
+			return
+		}
+	} else {
+		// Get User Info
+		userID, err := spotify.GetUserID(&spotifyAuthDetails)
+		if err != nil {
+			http.Error(w, "Could not get Spotify user info", http.StatusInternalServerError)
+			return
+		}
+
+		// Create playlist on Spotify
+		details, err := portData.details(portData.LastFmUsername, len(topTracks), portData.source(), portData.periodName(), time.Now())
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		playlist, replace, err := portData.targetPlaylist(userID, details, &spotifyAuthDetails)
+		if err != nil {
+			http.Error(w, "Could not create playlist on spotify", http.StatusInternalServerError)
+			return
+		}
+
+		// Add tracks to Spotify
+		result, err = addTracks(playlist, topTracks, &spotifyAuthDetails, replace)
+		if err != nil {
+			http.Error(w, "Could not add the tracks to the playlist on spotify", http.StatusInternalServerError)
+			return
+		}
+	}
+
+	values := map[string]interface{}{"tracks": topTracks, "tracksNotFound": result.TracksNotFound, "failedChunks": result.FailedChunks, "alreadySaved": result.AlreadySaved}
+	jsonValue, err := json.Marshal(values)
+
+	w.Header().Set("Content-type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write(jsonValue)
+}
+
+// trackEvent is the data sent with the events for each track
+type trackEvent struct {
+	Index int           `json:"index"`
+	Total int           `json:"total"`
+	Track spotify.Track `json:"track"`
+}
+
+// PortTopTracksStreamHandler ports a user's top tracks to a Spotify playlist like PortTopTracksHandler
+// but streams its progress as Server-Sent Events. An event is sent when each track is searched for
+// and when it is matched or not found, followed by a playlist-created event, or playlist-found when
+// an existing playlist is being replaced, and a tracks-added event. Ports to Liked Songs go
+// straight to the tracks-added event.
+// Errors after the stream has started are sent as an error event.
+func PortTopTracksStreamHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	var portData portPlaylistData
+
+	err := json.NewDecoder(r.Body).Decode(&portData)
 	if err != nil {
-		http.Error(w, "Could not get Spotify user info", http.StatusInternalServerError)
+		http.Error(w, "Malformed JSON", http.StatusBadRequest)
+		return
+	}
+
+	if err = portData.validate(); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
 
-	// Create playlist on Spotify
-	playlist, err := spotify.CreatePlaylist(userID, &spotifyAuthDetails)
+	topTracks, err := getTracksLastFm(portData)
+	if lastfm.IsInvalidParameters(err) {
+		http.Error(w, "No songs found on Last.fm. Check the username", http.StatusBadRequest)
+		return
+	}
 	if err != nil {
-		http.Error(w, "Could not create playlist on spotify", http.StatusInternalServerError)
+		http.Error(w, "Could not get tracks data from LastFm", http.StatusInternalServerError)
 		return
 	}
 
-	// Add tracks to Spotify
-	tracksNotFound, err := spotify.AddTracksToPlaylist(playlist, topTracks, &spotifyAuthDetails)
+	if len(topTracks) <= 0 {
+		http.Error(w, "No songs found on Last.fm. Check the username", http.StatusBadRequest)
+		return
+	}
+
+	stream, err := newEventStream(w)
 	if err != nil {
-		http.Error(w, "Could not add the tracks to the new playlist on spotify", http.StatusInternalServerError)
+		http.Error(w, "Could not stream the progress. Try again or contact me.", http.StatusInternalServerError)
 		return
 	}
 
-	// Put Spotify Auth details back into the body
-	values := map[string][]spotify.Track{"tracksNotFound": tracksNotFound}
-	jsonValue, err := json.Marshal(values)
+	err = getTracksSpotifyURIs(topTracks, func(event string, i int, track spotify.Track) {
+		stream.send(event, trackEvent{Index: i, Total: len(topTracks), Track: track})
+	})
+	if err != nil {
+		stream.sendError("Could not get Spotify URIs for tracks")
+		return
+	}
 
-	w.Header().Set("Content-type", "application/json")
-	w.WriteHeader(http.StatusOK)
-	w.Write(jsonValue)
-}
+	spotifyAuthDetails := r.Context().Value(middleware.AuthCxtKey).(spotify.AuthDetails)
 
-func getTopTracksLastFm(portData portPlaylistData) ([]spotify.Track, error) {
-	urlParams := fmt.Sprintf("&user=%s&api_key=%s&format=json&period=%s&limit=%s", portData.LastFmUsername, conf.Config.LastFm.APIKey, portData.TimePeriod, portData.SongNumber)
-	req, err := http.NewRequest(http.MethodGet, conf.Config.LastFm.UserTopTracksEndpoint+urlParams, strings.NewReader(""))
+	if portData.liked() {
+		result, err := saveLikedTracks(topTracks, &spotifyAuthDetails)
+		if err != nil {
+			stream.sendError("Could not save the tracks to your Liked Songs on spotify")
+			return
+		}
+		stream.send(eventTracksAdded, result)
+		return
+	}
+
+	userID, err := spotify.GetUserID(&spotifyAuthDetails)
 	if err != nil {
-		return nil, err
+		stream.sendError("Could not get Spotify user info")
+		return
 	}
 
-	client := http.Client{
-		Timeout: time.Duration(5 * time.Second),
+	details, err := portData.details(portData.LastFmUsername, len(topTracks), portData.source(), portData.periodName(), time.Now())
+	if err != nil {
+		stream.sendError(err.Error())
+		return
 	}
-	res, err := client.Do(req)
+	playlist, replace, err := portData.targetPlaylist(userID, details, &spotifyAuthDetails)
 	if err != nil {
-		return nil, err
+		stream.sendError("Could not create playlist on spotify")
+		return
+	}
+	if replace {
+		stream.send(eventPlaylistFound, playlist)
+	} else {
+		stream.send(eventPlaylistCreated, playlist)
 	}
 
-	defer res.Body.Close()
-	body, err := ioutil.ReadAll(res.Body)
+	result, err := addTracks(playlist, topTracks, &spotifyAuthDetails, replace)
+	if err != nil {
+		stream.sendError("Could not add the tracks to the new playlist on spotify")
+		return
+	}
+	stream.send(eventTracksAdded, result)
+}
+
+// addResult is the outcome of adding tracks to a playlist or Liked Songs
+type addResult struct {
+	Added          int                  `json:"added"`
+	TracksNotFound []spotify.Track      `json:"tracksNotFound"`
+	FailedChunks   []spotify.ChunkError `json:"failedChunks"`
+	// AlreadySaved is the number of tracks that were skipped because they were already in Liked Songs
+	AlreadySaved int `json:"alreadySaved,omitempty"`
+}
+
+// addTracks adds the tracks that were found on Spotify to the playlist, or replaces the playlist's
+// tracks with them if replace is set. Chunks of tracks that
+// couldn't be added are reported in the result, and an error is only returned if no tracks
+// could be added at all.
+func addTracks(playlist spotify.Playlist, tracks []spotify.Track, authDetails *spotify.AuthDetails, replace bool) (addResult, error) {
+	add := spotify.AddTracksToPlaylist
+	if replace {
+		add = spotify.ReplacePlaylistTracks
+	}
+
+	tracksNotFound, err := add(playlist, tracks, authDetails)
+	result := addResult{
+		Added:          len(tracks) - len(tracksNotFound),
+		TracksNotFound: tracksNotFound,
+	}
+
+	if addErr, ok := err.(*spotify.AddTracksError); ok {
+		log.Print(addErr)
+		if addErr.Added == 0 {
+			return result, err
+		}
+		result.Added = addErr.Added
+		result.FailedChunks = addErr.Chunks
+		return result, nil
+	}
+	return result, err
+}
+
+// saveLikedTracks saves the tracks that were found on Spotify to the user's Liked Songs, skipping
+// those that are already saved. Like addTracks, an error is only returned if no tracks could be
+// saved at all.
+func saveLikedTracks(tracks []spotify.Track, authDetails *spotify.AuthDetails) (addResult, error) {
+	saved, tracksNotFound, alreadySaved, err := spotify.SaveTracks(tracks, authDetails)
+	result := addResult{
+		Added:          saved,
+		TracksNotFound: tracksNotFound,
+		AlreadySaved:   alreadySaved,
+	}
+
+	if addErr, ok := err.(*spotify.AddTracksError); ok {
+		log.Print(addErr)
+		if addErr.Added == 0 && alreadySaved == 0 {
+			return result, err
+		}
+		result.FailedChunks = addErr.Chunks
+		return result, nil
+	}
+	return result, err
+}
+
+// getTracksLastFm gets the tracks the port asks for from Last.fm
+func getTracksLastFm(portData portPlaylistData) ([]spotify.Track, error) {
+	switch portData.source() {
+	case sourceLoved:
+		return getLovedTracksLastFm(portData)
+	case sourceRecent:
+		return getRecentTracksLastFm(portData)
+	}
+	return getTopTracksLastFm(portData)
+}
+
+// getLovedTracksLastFm gets the user's loved tracks, most recently loved first. Like top tracks
+// they are asked for a page at a time until there are enough or the user has no more.
+func getLovedTracksLastFm(portData portPlaylistData) ([]spotify.Track, error) {
+	count, err := portData.songCount()
 	if err != nil {
 		return nil, err
 	}
 
-	// extract required information from response
-	var lastFmTopTracks LastFmUserTopTracks
-	err = json.Unmarshal(body, &lastFmTopTracks)
+	var tracks []spotify.Track
+	for page := 1; len(tracks) < count; page++ {
+		urlParams := fmt.Sprintf("&user=%s&api_key=%s&format=json&limit=%d&page=%d", url.QueryEscape(portData.LastFmUsername), conf.Config.LastFm.APIKey, lastFmPageSize, page)
+
+		var lastFmLovedTracks LastFmUserLovedTracks
+		err := lastfm.GetCachedJSON(conf.Config.LastFm.UserLovedTracksEndpoint+urlParams, &lastFmLovedTracks)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, t := range lastFmLovedTracks.Lovedtracks.Tracks {
+			tracks = append(tracks, spotify.Track{
+				Artist: t.Artist.Name,
+				Title:  t.Name,
+			})
+		}
+
+		totalPages, _ := strconv.Atoi(lastFmLovedTracks.Lovedtracks.Attr.TotalPages)
+		if page >= totalPages || len(lastFmLovedTracks.Lovedtracks.Tracks) == 0 {
+			break
+		}
+	}
+
+	if len(tracks) > count {
+		tracks = tracks[:count]
+	}
+	return tracks, nil
+}
+
+// getTopTracksLastFm gets the user's top tracks for the period. Last.fm is asked for them a page
+// at a time until there are enough tracks or the user has no more.
+func getTopTracksLastFm(portData portPlaylistData) ([]spotify.Track, error) {
+	count, err := portData.songCount()
 	if err != nil {
 		return nil, err
 	}
 
-	// convert to a more suitable data structure
-	var tracks = make([]spotify.Track, len(lastFmTopTracks.Toptracks.Tracks))
-	for i, t := range lastFmTopTracks.Toptracks.Tracks {
-		track := spotify.Track{
-			Artist: t.Artist.Name,
-			Title:  t.Name,
+	var tracks []spotify.Track
+	for page := 1; len(tracks) < count; page++ {
+		urlParams := fmt.Sprintf("&user=%s&api_key=%s&format=json&period=%s&limit=%d&page=%d", url.QueryEscape(portData.LastFmUsername), conf.Config.LastFm.APIKey, portData.TimePeriod, lastFmPageSize, page)
+
+		// extract required information from response
+		var lastFmTopTracks LastFmUserTopTracks
+		err := lastfm.GetCachedJSON(conf.Config.LastFm.UserTopTracksEndpoint+urlParams, &lastFmTopTracks)
+		if err != nil {
+			return nil, err
+		}
+
+		// convert to a more suitable data structure
+		for _, t := range lastFmTopTracks.Toptracks.Tracks {
+			// Last.fm doesn't know the length of every track and sends 0 for those
+			duration, _ := strconv.Atoi(t.Duration)
+			tracks = append(tracks, spotify.Track{
+				Artist:   t.Artist.Name,
+				Title:    t.Name,
+				Duration: duration,
+			})
+		}
+
+		totalPages, _ := strconv.Atoi(lastFmTopTracks.Toptracks.Attr.TotalPages)
+		if page >= totalPages || len(lastFmTopTracks.Toptracks.Tracks) == 0 {
+			break
 		}
-		tracks[i] = track
 	}
 
+	if len(tracks) > count {
+		tracks = tracks[:count]
+	}
 	return tracks, nil
 }
 
-// GetTracksSpotifyURIs gets the Spotify URI for each track in a slice of tracks
-func getTracksSpotifyURIs(tracks []spotify.Track) error {
+// trackProgress is told about each track as its Spotify URI is searched for
+type trackProgress func(event string, i int, track spotify.Track)
 
-	clientAccessToken, err := spotify.GetClientAccessToken()
-	if err != nil {
+// getTracksSpotifyURIs gets the Spotify URI and match score for each track in a slice of tracks.
+// Tracks without a confident match are left without a URI. The searches are made by a pool of
+// workers but each URI is stored with its track so the order is kept. If progress isn't nil it is
+// told when each search starts and whether the track was found. It may be called from several
+// goroutines at once.
+func getTracksSpotifyURIs(tracks []spotify.Track, progress trackProgress) error {
+	if progress == nil {
+		progress = func(string, int, spotify.Track) {}
+	}
+
+	// Fail before searching if there is no token, later searches get it again as it can expire
+	if _, err := spotify.GetClientAccessToken(); err != nil {
 		return err
 	}
 
-	for i := 0; i < len(tracks); i++ {
-		uri, err := spotify.GetTrackSpotifyURI(tracks[i].Artist, tracks[i].Title, clientAccessToken)
-		if err == nil {
-			tracks[i].SpotifyURI = uri
+	forEachTrack(len(tracks), func(i int) {
+		progress(eventSearching, i, tracks[i])
+		var m match.Match
+		var ok bool
+		err := spotify.WithClientToken(func(clientAccessToken string) (err error) {
+			m, ok, err = match.FindTrack(tracks[i], clientAccessToken)
+			return err
+		})
+		if err != nil {
+			log.Print(err)
+		} else if ok {
+			tracks[i].SpotifyURI = m.Candidate.URI
+			tracks[i].Score = m.Score
+			tracks[i].Strategy = m.Strategy
 		}
-	}
+
+		if tracks[i].SpotifyURI != "" {
+			progress(eventMatched, i, tracks[i])
+		} else {
+			progress(eventNotFound, i, tracks[i])
+		}
+	})
 	return nil
 }
+
+// forEachTrack calls fn with the index of each of n tracks using a pool of spotify.SearchConcurrency
+// workers, and returns once every call has finished
+func forEachTrack(n int, fn func(i int)) {
+	jobs := make(chan int)
+	var wg sync.WaitGroup
+
+	workers := spotify.SearchConcurrency
+	if workers > n {
+		workers = n
+	}
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				fn(i)
+			}
+		}()
+	}
+
+	for i := 0; i < n; i++ {
+		jobs <- i
+	}
+	close(jobs)
+	wg.Wait()
+}
@@ -0,0 +1,113 @@
+package playlist
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+
+	"github.com/conorbros/las-tools/conf"
+)
+
+// tracksServer serves total tracks from Last.fm a page at a time in the response object named root
+func tracksServer(root string, total int) (*httptest.Server, *int) {
+	var requests int
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		limit, _ := strconv.Atoi(r.URL.Query().Get("limit"))
+		page, _ := strconv.Atoi(r.URL.Query().Get("page"))
+		totalPages := (total + limit - 1) / limit
+
+		fmt.Fprintf(w, `{"%s":{"track":[`, root)
+		for i := (page - 1) * limit; i < page*limit && i < total; i++ {
+			if i > (page-1)*limit {
+				fmt.Fprint(w, ",")
+			}
+			fmt.Fprintf(w, `{"name":"Track %d","duration":"180","artist":{"name":"Artist"}}`, i)
+		}
+		fmt.Fprintf(w, `],"@attr":{"totalPages":"%d"}}}`, totalPages)
+	})), &requests
+}
+
+func TestGetTopTracksLastFmPaginates(t *testing.T) {
+	server, requests := tracksServer("toptracks", 450)
+	defer server.Close()
+	conf.Config.LastFm.UserTopTracksEndpoint = server.URL + "/?method=user.gettoptracks"
+
+	tracks, err := getTopTracksLastFm(portPlaylistData{LastFmUsername: "paginated", SongNumber: "300", TimePeriod: "overall"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(tracks) != 300 {
+		t.Fatalf("got %d tracks; want 300", len(tracks))
+	}
+	if tracks[299].Title != "Track 299" || tracks[0].Duration != 180 {
+		t.Errorf("tracks are out of order or incomplete: %+v, %+v", tracks[0], tracks[299])
+	}
+	if *requests != 2 {
+		t.Errorf("made %d requests; want 2", *requests)
+	}
+
+	// Asking for more tracks than the user has stops at the last page
+	tracks, err = getTopTracksLastFm(portPlaylistData{LastFmUsername: "paginated", SongNumber: "1000", TimePeriod: "7day"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(tracks) != 450 {
+		t.Errorf("got %d tracks; want 450", len(tracks))
+	}
+}
+
+func TestGetLovedTracksLastFmPaginates(t *testing.T) {
+	server, requests := tracksServer("lovedtracks", 2500)
+	defer server.Close()
+	conf.Config.LastFm.UserLovedTracksEndpoint = server.URL + "/?method=user.getlovedtracks"
+
+	// Every loved track is ported when the number of songs isn't given
+	tracks, err := getTracksLastFm(portPlaylistData{LastFmUsername: "loved", Source: sourceLoved})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(tracks) != 2500 {
+		t.Fatalf("got %d tracks; want 2500", len(tracks))
+	}
+	if tracks[0].Title != "Track 0" || tracks[2499].Title != "Track 2499" || tracks[0].Artist != "Artist" {
+		t.Errorf("tracks are out of order or incomplete: %+v, %+v", tracks[0], tracks[2499])
+	}
+	if *requests != 13 {
+		t.Errorf("made %d requests; want 13", *requests)
+	}
+}
+
+func TestPortPlaylistDataValidate(t *testing.T) {
+	tests := []struct {
+		data  portPlaylistData
+		valid bool
+	}{
+		{portPlaylistData{LastFmUsername: "rj", SongNumber: "1000", TimePeriod: "overall"}, true},
+		{portPlaylistData{LastFmUsername: "rj", SongNumber: "1001", TimePeriod: "overall"}, false},
+		{portPlaylistData{LastFmUsername: "rj", SongNumber: "0", TimePeriod: "overall"}, false},
+		{portPlaylistData{LastFmUsername: "rj", SongNumber: "ten", TimePeriod: "overall"}, false},
+		{portPlaylistData{SongNumber: "10", TimePeriod: "overall"}, false},
+		{portPlaylistData{LastFmUsername: "rj", SongNumber: "10", TimePeriod: "1year"}, false},
+		{portPlaylistData{LastFmUsername: "rj", SongNumber: "10", TimePeriod: "7day", playlistOptions: playlistOptions{PlaylistName: "{usr}'s songs"}}, false},
+		{portPlaylistData{LastFmUsername: "rj", Source: sourceLoved}, true},
+		{portPlaylistData{LastFmUsername: "rj", Source: sourceLoved, SongNumber: "5000"}, true},
+		{portPlaylistData{LastFmUsername: "rj", Source: sourceLoved, SongNumber: "10001"}, false},
+		{portPlaylistData{LastFmUsername: "rj", Source: "friends", SongNumber: "10"}, false},
+		{portPlaylistData{LastFmUsername: "rj", Source: sourceLoved, playlistOptions: playlistOptions{Destination: destinationLiked}}, true},
+		{portPlaylistData{LastFmUsername: "rj", Source: sourceLoved, playlistOptions: playlistOptions{Destination: destinationLiked, TargetPlaylistName: "Loved"}}, false},
+		{portPlaylistData{LastFmUsername: "rj", Source: sourceLoved, playlistOptions: playlistOptions{Destination: "library"}}, false},
+		{portPlaylistData{LastFmUsername: "rj", Source: sourceRecent, recentOptions: recentOptions{From: 1601683200, To: 1601855999}}, true},
+		{portPlaylistData{LastFmUsername: "rj", Source: sourceRecent, recentOptions: recentOptions{From: 1601683200, To: 1601855999, Order: orderPlays}}, true},
+		{portPlaylistData{LastFmUsername: "rj", Source: sourceRecent, recentOptions: recentOptions{From: 1601855999, To: 1601683200}}, false},
+		{portPlaylistData{LastFmUsername: "rj", Source: sourceRecent}, false},
+		{portPlaylistData{LastFmUsername: "rj", Source: sourceRecent, recentOptions: recentOptions{From: 1601683200, To: 1601855999, Order: "random"}}, false},
+	}
+	for _, test := range tests {
+		if err := test.data.validate(); (err == nil) != test.valid {
+			t.Errorf("validate(%+v) = %v; want valid %v", test.data, err, test.valid)
+		}
+	}
+}
@@ -0,0 +1,263 @@
+package playlist
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/conorbros/las-tools/lastfm"
+	"github.com/conorbros/las-tools/match"
+	"github.com/conorbros/las-tools/middleware"
+	"github.com/conorbros/las-tools/spotify"
+)
+
+// maxAlternates is the number of other candidates sent with each track in a preview
+const maxAlternates = 5
+
+// previewCandidate is a Spotify track that may match a Last.fm track
+type previewCandidate struct {
+	URI      string   `json:"uri"`
+	Name     string   `json:"name"`
+	Artists  []string `json:"artists"`
+	Album    string   `json:"album"`
+	Score    float64  `json:"score"`
+	Strategy string   `json:"strategy"`
+}
+
+// previewTrack is a Last.fm track with the Spotify track that would be added for it, if
+// there is a confident match, and the other candidates that were found
+type previewTrack struct {
+	Artist     string             `json:"artist"`
+	Title      string             `json:"title"`
+	Chosen     *previewCandidate  `json:"chosen"`
+	Alternates []previewCandidate `json:"alternates"`
+	Confidence float64            `json:"confidence"`
+}
+
+// commitData is the list of Spotify track URIs to add to the new playlist, in order, with the
+// settings for the playlist. The Last.fm username, source, period and recent dates are only used
+// in the name and description.
+type commitData struct {
+	URIs           []string `json:"uris"`
+	LastFmUsername string
+	Source         string
+	TimePeriod     string
+	recentOptions
+	playlistOptions
+}
+
+// maxURIs is the most tracks that can be committed at once. As with loved tracks more can be
+// saved to Liked Songs than added to a playlist.
+func (data commitData) maxURIs() int {
+	if data.liked() {
+		return maxLovedSongNumber
+	}
+	return maxSongNumber
+}
+
+func newPreviewCandidate(m match.Match) previewCandidate {
+	c := previewCandidate{
+		URI:      m.Candidate.URI,
+		Name:     m.Candidate.Name,
+		Album:    m.Candidate.Album.Name,
+		Score:    m.Score,
+		Strategy: m.Strategy,
+	}
+	for _, a := range m.Candidate.Artists {
+		c.Artists = append(c.Artists, a.Name)
+	}
+	return c
+}
+
+// PreviewTopTracksHandler gets a user's top, loved or recent tracks from Last.fm and matches them
+// on Spotify without changing the user's Spotify account. Only logged in users can preview as
+// the searches use the app's Spotify rate limit. Each track is returned with the candidate that would be
+// added, the alternates and how confident the match is, so the list can be reviewed and edited
+// before it is sent to CommitTopTracksHandler.
+func PreviewTopTracksHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	var portData portPlaylistData
+
+	err := json.NewDecoder(r.Body).Decode(&portData)
+	if err != nil {
+		http.Error(w, "Malformed JSON", http.StatusBadRequest)
+		return
+	}
+
+	if err = portData.validate(); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	// Every previewed track is searched for on Spotify so previews are kept to maxSongNumber
+	// tracks whatever the source
+	if n, _ := portData.songCount(); n > maxSongNumber {
+		if portData.SongNumber != "" {
+			http.Error(w, fmt.Sprintf("A preview can have at most %d songs", maxSongNumber), http.StatusBadRequest)
+			return
+		}
+		portData.SongNumber = strconv.Itoa(maxSongNumber)
+	}
+
+	topTracks, err := getTracksLastFm(portData)
+	if lastfm.IsInvalidParameters(err) {
+		http.Error(w, "No songs found on Last.fm. Check the username", http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Could not get tracks data from LastFm", http.StatusInternalServerError)
+		return
+	}
+
+	if len(topTracks) <= 0 {
+		http.Error(w, "No songs found on Last.fm. Check the username", http.StatusBadRequest)
+		return
+	}
+
+	preview, err := previewTracks(topTracks)
+	if err != nil {
+		http.Error(w, "Could not get Spotify URIs for tracks", http.StatusInternalServerError)
+		return
+	}
+
+	jsonValue, err := json.Marshal(map[string][]previewTrack{"tracks": preview})
+	if err != nil {
+		http.Error(w, "Could not get Spotify URIs for tracks", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write(jsonValue)
+}
+
+// previewTracks finds the Spotify candidates for each track, keeping the order of the tracks
+func previewTracks(tracks []spotify.Track) ([]previewTrack, error) {
+	if _, err := spotify.GetClientAccessToken(); err != nil {
+		return nil, err
+	}
+
+	preview := make([]previewTrack, len(tracks))
+	forEachTrack(len(tracks), func(i int) {
+		p := previewTrack{
+			Artist:     tracks[i].Artist,
+			Title:      tracks[i].Title,
+			Alternates: []previewCandidate{},
+		}
+
+		var matches []match.Match
+		var ok bool
+		err := spotify.WithClientToken(func(clientAccessToken string) (err error) {
+			matches, ok, err = match.FindCandidates(tracks[i], clientAccessToken)
+			return err
+		})
+		if err != nil {
+			log.Print(err)
+		}
+		if ok {
+			chosen := newPreviewCandidate(matches[0])
+			p.Chosen = &chosen
+			p.Confidence = matches[0].Score
+			matches = matches[1:]
+		}
+		for j := 0; j < len(matches) && j < maxAlternates; j++ {
+			p.Alternates = append(p.Alternates, newPreviewCandidate(matches[j]))
+		}
+		preview[i] = p
+	})
+	return preview, nil
+}
+
+// CommitTopTracksHandler creates a playlist on the user's Spotify account, or replaces the contents
+// of an existing one, with the tracks chosen after previewing a port, in the order they are sent.
+// The tracks are saved to the user's Liked Songs instead when that is the destination.
+func CommitTopTracksHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	var data commitData
+
+	err := json.NewDecoder(r.Body).Decode(&data)
+	if err != nil {
+		http.Error(w, "Malformed JSON", http.StatusBadRequest)
+		return
+	}
+
+	if len(data.URIs) == 0 {
+		http.Error(w, "No songs were chosen for the playlist", http.StatusBadRequest)
+		return
+	}
+	if len(data.URIs) > data.maxURIs() {
+		http.Error(w, fmt.Sprintf("At most %d songs can be ported at once", data.maxURIs()), http.StatusBadRequest)
+		return
+	}
+
+	tracks := make([]spotify.Track, len(data.URIs))
+	for i, uri := range data.URIs {
+		if !strings.HasPrefix(uri, spotify.TrackURIPrefix) {
+			http.Error(w, "Invalid Spotify track URI: "+uri, http.StatusBadRequest)
+			return
+		}
+		tracks[i].SpotifyURI = uri
+	}
+
+	// The port the tracks were previewed from names the playlist
+	port := portPlaylistData{LastFmUsername: data.LastFmUsername, Source: data.Source, TimePeriod: data.TimePeriod, recentOptions: data.recentOptions}
+	details, err := data.details(port.LastFmUsername, len(tracks), port.source(), port.periodName(), time.Now())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	spotifyAuthDetails := r.Context().Value(middleware.AuthCxtKey).(spotify.AuthDetails)
+
+	if data.liked() {
+		result, err := saveLikedTracks(tracks, &spotifyAuthDetails)
+		if err != nil {
+			http.Error(w, "Could not save the tracks to your Liked Songs on spotify", http.StatusInternalServerError)
+			return
+		}
+		writeCommitResult(w, map[string]interface{}{"added": result.Added, "alreadySaved": result.AlreadySaved, "failedChunks": result.FailedChunks})
+		return
+	}
+
+	userID, err := spotify.GetUserID(&spotifyAuthDetails)
+	if err != nil {
+		http.Error(w, "Could not get Spotify user info", http.StatusInternalServerError)
+		return
+	}
+
+	playlist, replace, err := data.targetPlaylist(userID, details, &spotifyAuthDetails)
+	if err != nil {
+		http.Error(w, "Could not create playlist on spotify", http.StatusInternalServerError)
+		return
+	}
+
+	result, err := addTracks(playlist, tracks, &spotifyAuthDetails, replace)
+	if err != nil {
+		http.Error(w, "Could not add the tracks to the new playlist on spotify", http.StatusInternalServerError)
+		return
+	}
+
+	writeCommitResult(w, map[string]interface{}{"playlist": playlist, "added": result.Added, "failedChunks": result.FailedChunks})
+}
+
+func writeCommitResult(w http.ResponseWriter, result map[string]interface{}) {
+	jsonValue, err := json.Marshal(result)
+	if err != nil {
+		http.Error(w, "Could not add the tracks to the new playlist on spotify", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write(jsonValue)
+}
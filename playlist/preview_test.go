@@ -0,0 +1,154 @@
+package playlist
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/conorbros/las-tools/conf"
+)
+
+func TestCommitTopTracksHandlerValidatesURIs(t *testing.T) {
+	tests := []struct {
+		body   string
+		status int
+	}{
+		{`{"uris":[]}`, http.StatusBadRequest},
+		{`{"uris":["spotify:track:1","https://evil.example"]}`, http.StatusBadRequest},
+		{`not json`, http.StatusBadRequest},
+	}
+	for _, test := range tests {
+		w := httptest.NewRecorder()
+		CommitTopTracksHandler(w, httptest.NewRequest("POST", "/port_toptracks/commit", strings.NewReader(test.body)))
+		if w.Code != test.status {
+			t.Errorf("body %s: status = %d; want %d", test.body, w.Code, test.status)
+		}
+	}
+}
+
+func TestCommitTopTracksHandlerLimitsURIs(t *testing.T) {
+	uris := func(n int) string {
+		quoted := make([]string, n)
+		for i := range quoted {
+			quoted[i] = `"spotify:track:` + strconv.Itoa(i) + `"`
+		}
+		return "[" + strings.Join(quoted, ",") + "]"
+	}
+
+	tests := []string{
+		`{"uris":` + uris(maxSongNumber+1) + `}`,
+		`{"destination":"liked","uris":` + uris(maxLovedSongNumber+1) + `}`,
+	}
+	for _, body := range tests {
+		w := httptest.NewRecorder()
+		CommitTopTracksHandler(w, httptest.NewRequest("POST", "/port_toptracks/commit", strings.NewReader(body)))
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("status = %d; want %d", w.Code, http.StatusBadRequest)
+		}
+	}
+}
+
+// previewServers serve a Spotify client token and searches that find nothing so previews can
+// be made without Spotify
+func previewServers() func() {
+	token := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `{"access_token":"token"}`)
+	}))
+	search := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `{"tracks":{"items":[]}}`)
+	}))
+	conf.Config.Spotify.TokenEndpoint = token.URL
+	conf.Config.Spotify.SearchEndpoint = search.URL
+	return func() {
+		token.Close()
+		search.Close()
+	}
+}
+
+func TestPreviewTopTracksHandlerUsesSource(t *testing.T) {
+	defer previewServers()()
+	server, _ := tracksServer("lovedtracks", 3)
+	defer server.Close()
+	conf.Config.LastFm.UserLovedTracksEndpoint = server.URL + "/?method=user.getlovedtracks"
+
+	w := httptest.NewRecorder()
+	PreviewTopTracksHandler(w, httptest.NewRequest("POST", "/port_toptracks/preview", strings.NewReader(`{"lastFmUsername":"preview-loved","source":"loved"}`)))
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d: %s", w.Code, w.Body)
+	}
+
+	var res struct {
+		Tracks []previewTrack `json:"tracks"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &res); err != nil {
+		t.Fatal(err)
+	}
+	if len(res.Tracks) != 3 || res.Tracks[2].Title != "Track 2" {
+		t.Errorf("previewed %+v; want the 3 loved tracks", res.Tracks)
+	}
+}
+
+func TestPreviewTopTracksHandlerUsesRecentDates(t *testing.T) {
+	defer previewServers()()
+	server, requests := recentTracksServer()
+	defer server.Close()
+	conf.Config.LastFm.UserRecentTracksEndpoint = server.URL + "/?method=user.getrecenttracks"
+
+	body := `{"lastFmUsername":"rj","source":"recent","from":1600000000,"to":1600001000,"order":"plays"}`
+	w := httptest.NewRecorder()
+	PreviewTopTracksHandler(w, httptest.NewRequest("POST", "/port_toptracks/preview", strings.NewReader(body)))
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d: %s", w.Code, w.Body)
+	}
+
+	var res struct {
+		Tracks []previewTrack `json:"tracks"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &res); err != nil {
+		t.Fatal(err)
+	}
+	var titles []string
+	for _, track := range res.Tracks {
+		titles = append(titles, track.Title)
+	}
+	if want := "[Song 1 Song 2 Song 3]"; fmt.Sprint(titles) != want {
+		t.Errorf("previewed %v; want %s", titles, want)
+	}
+	if *requests != 3 {
+		t.Errorf("made %d requests to Last.fm; want 3", *requests)
+	}
+}
+
+func TestPreviewTopTracksHandlerLimitsSongs(t *testing.T) {
+	defer previewServers()()
+	server, _ := tracksServer("lovedtracks", maxSongNumber+1)
+	defer server.Close()
+	conf.Config.LastFm.UserLovedTracksEndpoint = server.URL + "/?method=user.getlovedtracks"
+
+	// Asking for more than maxSongNumber is an error
+	w := httptest.NewRecorder()
+	PreviewTopTracksHandler(w, httptest.NewRequest("POST", "/port_toptracks/preview", strings.NewReader(`{"lastFmUsername":"preview-limit","source":"loved","songNumber":"5000"}`)))
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("status = %d; want %d", w.Code, http.StatusBadRequest)
+	}
+
+	// Previewing every loved track stops at maxSongNumber
+	w = httptest.NewRecorder()
+	PreviewTopTracksHandler(w, httptest.NewRequest("POST", "/port_toptracks/preview", strings.NewReader(`{"lastFmUsername":"preview-limit","source":"loved"}`)))
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d: %s", w.Code, w.Body)
+	}
+	var res struct {
+		Tracks []previewTrack `json:"tracks"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &res); err != nil {
+		t.Fatal(err)
+	}
+	if len(res.Tracks) != maxSongNumber {
+		t.Errorf("previewed %d tracks; want %d", len(res.Tracks), maxSongNumber)
+	}
+}
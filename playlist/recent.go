@@ -0,0 +1,155 @@
+package playlist
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/conorbros/las-tools/conf"
+	"github.com/conorbros/las-tools/lastfm"
+	"github.com/conorbros/las-tools/spotify"
+)
+
+// maxRecentPages is the most pages of scrobbles read for a port of recent tracks. Only the
+// latest maxRecentPages*lastFmPageSize plays in the range are counted.
+const maxRecentPages = 100
+
+// How recent tracks can be ordered
+const (
+	orderChronological = "chronological"
+	orderPlays         = "plays"
+)
+
+// recentOptions are the settings for a port of the tracks a user scrobbled between two times
+type recentOptions struct {
+	// From and To are the Unix times of the first and last scrobbles to include
+	From int64
+	To   int64
+	// Order is how the tracks are ordered, by when each was first played in the range or by how
+	// many times each was played. They are ordered chronologically when the request doesn't say.
+	Order string
+}
+
+// validate checks the recent tracks options, returning an error with a message for the user if they aren't valid
+func (o recentOptions) validate() error {
+	if o.From <= 0 || o.To <= o.From {
+		return errors.New("Choose the dates to port listening from and to")
+	}
+	switch o.Order {
+	case "", orderChronological, orderPlays:
+	default:
+		return errors.New("Choose whether to order the songs chronologically or by play count")
+	}
+	return nil
+}
+
+// periodName gets how the dates of the recent tracks are written in playlist names
+func (o recentOptions) periodName() string {
+	from := time.Unix(o.From, 0).UTC().Format("2006-01-02")
+	to := time.Unix(o.To, 0).UTC().Format("2006-01-02")
+	if from == to {
+		return from
+	}
+	return from + " to " + to
+}
+
+// LastFmUserRecentTracks represents the results retrieved from the LastFm API user recent tracks
+type LastFmUserRecentTracks struct {
+	Recenttracks struct {
+		Tracks []struct {
+			Artist struct {
+				Name string `json:"#text"`
+			} `json:"artist"`
+			Name string `json:"name"`
+			Date struct {
+				UTS string `json:"uts"`
+			} `json:"date"`
+			Attr struct {
+				NowPlaying string `json:"nowplaying"`
+			} `json:"@attr"`
+		} `json:"track"`
+		Attr struct {
+			TotalPages string `json:"totalPages"`
+		} `json:"@attr"`
+	} `json:"recenttracks"`
+}
+
+// recentTrack is a track scrobbled in the range with how often and when it was first played
+type recentTrack struct {
+	track     spotify.Track
+	plays     int
+	firstPlay int64
+}
+
+// getRecentTracksLastFm gets the tracks the user scrobbled between the port's From and To times.
+// Each track is included once however many times it was played, ordered by the port's Order.
+func getRecentTracksLastFm(portData portPlaylistData) ([]spotify.Track, error) {
+	count, err := portData.songCount()
+	if err != nil {
+		return nil, err
+	}
+
+	var played []*recentTrack
+	byKey := make(map[string]*recentTrack)
+	for page := 1; page <= maxRecentPages; page++ {
+		urlParams := fmt.Sprintf("&user=%s&api_key=%s&format=json&from=%d&to=%d&limit=%d&page=%d", url.QueryEscape(portData.LastFmUsername), conf.Config.LastFm.APIKey, portData.From, portData.To, lastFmPageSize, page)
+
+		var lastFmRecentTracks LastFmUserRecentTracks
+		err := lastfm.GetCachedJSON(conf.Config.LastFm.UserRecentTracksEndpoint+urlParams, &lastFmRecentTracks)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, t := range lastFmRecentTracks.Recenttracks.Tracks {
+			// The track playing now is listed first but hasn't been scrobbled yet
+			if t.Attr.NowPlaying == "true" {
+				continue
+			}
+			uts, _ := strconv.ParseInt(t.Date.UTS, 10, 64)
+
+			key := strings.ToLower(t.Artist.Name) + "\x00" + strings.ToLower(t.Name)
+			r, ok := byKey[key]
+			if !ok {
+				r = &recentTrack{track: spotify.Track{Artist: t.Artist.Name, Title: t.Name}, firstPlay: uts}
+				byKey[key] = r
+				played = append(played, r)
+			}
+			r.plays++
+			// Scrobbles are listed newest first so later pages have earlier plays
+			if uts < r.firstPlay {
+				r.firstPlay = uts
+			}
+		}
+
+		totalPages, _ := strconv.Atoi(lastFmRecentTracks.Recenttracks.Attr.TotalPages)
+		if page >= totalPages || len(lastFmRecentTracks.Recenttracks.Tracks) == 0 {
+			break
+		}
+	}
+
+	orderRecentTracks(played, portData.Order)
+
+	if len(played) > count {
+		played = played[:count]
+	}
+	tracks := make([]spotify.Track, len(played))
+	for i, r := range played {
+		tracks[i] = r.track
+	}
+	return tracks, nil
+}
+
+// orderRecentTracks sorts the tracks by when they were first played, oldest first, or by
+// how many times they were played, most first, with ties in the order they were first played
+func orderRecentTracks(played []*recentTrack, order string) {
+	sort.SliceStable(played, func(i, j int) bool {
+		if order == orderPlays && played[i].plays != played[j].plays {
+			return played[i].plays > played[j].plays
+		}
+		return played[i].firstPlay < played[j].firstPlay
+	})
+}
@@ -0,0 +1,100 @@
+package playlist
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+
+	"github.com/conorbros/las-tools/conf"
+)
+
+// recentScrobbles are served newest first, like Last.fm does, after the track playing now
+var recentScrobbles = []struct {
+	artist, title string
+	uts           int
+}{
+	{"Artist A", "Song 1", 1600000600},
+	{"Artist B", "Song 2", 1600000500},
+	{"Artist A", "Song 1", 1600000400},
+	{"artist a", "song 1", 1600000300},
+	{"Artist B", "Song 2", 1600000200},
+	{"Artist C", "Song 3", 1600000100},
+}
+
+// recentTracksServer serves recentScrobbles a page at a time
+func recentTracksServer() (*httptest.Server, *int) {
+	var requests int
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		page, _ := strconv.Atoi(r.URL.Query().Get("page"))
+		// Pages of two are used whatever the limit so a few scrobbles span several pages
+		limit := 2
+		totalPages := (len(recentScrobbles) + limit - 1) / limit
+
+		fmt.Fprint(w, `{"recenttracks":{"track":[`)
+		if page == 1 {
+			fmt.Fprint(w, `{"name":"Now Playing","artist":{"#text":"Artist D"},"@attr":{"nowplaying":"true"}},`)
+		}
+		for i := (page - 1) * limit; i < page*limit && i < len(recentScrobbles); i++ {
+			if i > (page-1)*limit {
+				fmt.Fprint(w, ",")
+			}
+			s := recentScrobbles[i]
+			fmt.Fprintf(w, `{"name":%q,"artist":{"#text":%q},"date":{"uts":"%d"}}`, s.title, s.artist, s.uts)
+		}
+		fmt.Fprintf(w, `],"@attr":{"totalPages":"%d"}}}`, totalPages)
+	})), &requests
+}
+
+func TestGetRecentTracksLastFm(t *testing.T) {
+	server, requests := recentTracksServer()
+	defer server.Close()
+	conf.Config.LastFm.UserRecentTracksEndpoint = server.URL + "/?method=user.getrecenttracks"
+
+	tests := []struct {
+		order      string
+		songNumber string
+		want       []string
+	}{
+		{"", "", []string{"Song 3", "Song 2", "Song 1"}},
+		{orderChronological, "2", []string{"Song 3", "Song 2"}},
+		{orderPlays, "", []string{"Song 1", "Song 2", "Song 3"}},
+	}
+	for _, test := range tests {
+		data := portPlaylistData{
+			LastFmUsername: "rj",
+			Source:         sourceRecent,
+			SongNumber:     test.songNumber,
+			recentOptions:  recentOptions{From: 1600000000, To: 1600001000, Order: test.order},
+		}
+		tracks, err := getTracksLastFm(data)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var titles []string
+		for _, track := range tracks {
+			titles = append(titles, track.Title)
+		}
+		if fmt.Sprint(titles) != fmt.Sprint(test.want) {
+			t.Errorf("order %q: got %v; want %v", test.order, titles, test.want)
+		}
+	}
+	// The pages are only requested once as the same range is read from the cache
+	if *requests != 3 {
+		t.Errorf("made %d requests; want 3", *requests)
+	}
+}
+
+func TestRecentOptionsPeriodName(t *testing.T) {
+	weekend := recentOptions{From: 1601683200, To: 1601855999}
+	if got, want := weekend.periodName(), "2020-10-03 to 2020-10-04"; got != want {
+		t.Errorf("periodName() = %q; want %q", got, want)
+	}
+	day := recentOptions{From: 1601683200, To: 1601769599}
+	if got, want := day.periodName(), "2020-10-03"; got != want {
+		t.Errorf("periodName() = %q; want %q", got, want)
+	}
+}
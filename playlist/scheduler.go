@@ -0,0 +1,92 @@
+package playlist
+
+import (
+	"errors"
+	"log"
+	"time"
+
+	"github.com/conorbros/las-tools/conf"
+	"github.com/conorbros/las-tools/spotify"
+)
+
+// StartScheduler runs the syncs that are due, checking for them every CheckIntervalSeconds.
+// Syncs are run one at a time so they don't compete for the Spotify rate limit.
+func StartScheduler() {
+	interval := time.Duration(conf.Config.Sync.CheckIntervalSeconds) * time.Second
+	go func() {
+		for {
+			for _, d := range syncs.due(time.Now()) {
+				run := runSync(&d)
+				if err := syncs.finish(d, run); err != nil {
+					logSyncError("Could not save the syncs", err)
+				}
+			}
+			time.Sleep(interval)
+		}
+	}()
+}
+
+func logSyncError(msg string, err error) {
+	log.Printf("%s: %v", msg, err)
+}
+
+// runSync fetches the user's top tracks, matches them on Spotify and replaces the contents of the
+// sync's playlist with them. The sync's refresh token and target playlist are updated in place.
+func runSync(d *syncDefinition) syncRun {
+	run := syncRun{Started: time.Now(), Unmatched: []spotify.Track{}}
+	finish := func(status string, err error) syncRun {
+		run.Status = status
+		if err != nil {
+			run.Error = err.Error()
+			logSyncError("Sync "+d.ID+" failed", err)
+		}
+		run.Finished = time.Now()
+		return run
+	}
+
+	authDetails := spotify.AuthDetails{RefreshToken: d.RefreshToken}
+	if err := spotify.RefreshAuth(&authDetails); err != nil {
+		return finish(runFailed, err)
+	}
+	if authDetails.AccessToken == "" {
+		return finish(runFailed, errors.New("Spotify did not refresh the access token. The user may have revoked access."))
+	}
+	// Spotify sometimes issues a new refresh token which replaces the old one
+	d.RefreshToken = authDetails.RefreshToken
+
+	tracks, err := getTracksLastFm(d.Port)
+	if err != nil {
+		return finish(runFailed, err)
+	}
+	if err = getTracksSpotifyURIs(tracks, nil); err != nil {
+		return finish(runFailed, err)
+	}
+
+	details, err := d.Port.details(d.Port.LastFmUsername, len(tracks), d.Port.source(), d.Port.periodName(), run.Started)
+	if err != nil {
+		return finish(runFailed, err)
+	}
+	playlist, replace, err := d.Port.targetPlaylist(d.SpotifyUserID, details, &authDetails)
+	if err != nil {
+		return finish(runFailed, err)
+	}
+	// Later runs replace the playlist this run used, even if it was created or found by name
+	d.Port.TargetPlaylistID = playlist.ID
+	d.Port.TargetPlaylistName = ""
+	run.PlaylistID = playlist.ID
+
+	result, err := addTracks(playlist, tracks, &authDetails, replace)
+	if err != nil {
+		return finish(runFailed, err)
+	}
+	run.Added = result.Added
+	run.Failed = result.FailedChunks
+	if result.TracksNotFound != nil {
+		run.Unmatched = result.TracksNotFound
+	}
+
+	if len(result.FailedChunks) > 0 {
+		return finish(runPartial, nil)
+	}
+	return finish(runSucceeded, nil)
+}
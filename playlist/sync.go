@@ -0,0 +1,363 @@
+package playlist
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/conorbros/las-tools/conf"
+	"github.com/conorbros/las-tools/middleware"
+	"github.com/conorbros/las-tools/spotify"
+)
+
+// syncsPath is the path syncs are served under
+const syncsPath = "/syncs/"
+
+// maxRuns is the number of past runs kept for each sync
+const maxRuns = 20
+
+// cadences are how often a sync can run
+var cadences = map[string]time.Duration{
+	"hourly": time.Hour,
+	"daily":  24 * time.Hour,
+	"weekly": 7 * 24 * time.Hour,
+}
+
+// Outcomes of a sync run
+const (
+	runSucceeded = "succeeded"
+	runPartial   = "partial"
+	runFailed    = "failed"
+)
+
+// syncs holds every sync definition, loaded from the store when the app starts
+var syncs = newSyncStore(conf.Config.Sync.StorePath)
+
+// syncDefinition is a playlist that is kept in step with a user's Last.fm top tracks by
+// replacing its contents on a schedule
+type syncDefinition struct {
+	ID      string           `json:"id"`
+	Port    portPlaylistData `json:"port"`
+	Cadence string           `json:"cadence"`
+
+	SpotifyUserID string `json:"spotifyUserId"`
+	// RefreshToken lets the sync act for the user after they have left. It is never sent to clients.
+	RefreshToken string `json:"refreshToken,omitempty"`
+
+	Created time.Time `json:"created"`
+	NextRun time.Time `json:"nextRun"`
+	Runs    []syncRun `json:"runs"`
+
+	running bool
+}
+
+// syncRun is the outcome of one run of a sync
+type syncRun struct {
+	Started    time.Time            `json:"started"`
+	Finished   time.Time            `json:"finished"`
+	Status     string               `json:"status"`
+	Error      string               `json:"error,omitempty"`
+	PlaylistID string               `json:"playlistId,omitempty"`
+	Added      int                  `json:"added"`
+	Unmatched  []spotify.Track      `json:"unmatched"`
+	Failed     []spotify.ChunkError `json:"failedChunks,omitempty"`
+}
+
+// syncRequest is the body sent to create a sync
+type syncRequest struct {
+	portPlaylistData
+	Cadence string
+}
+
+// public gets a copy of the sync that is safe to send to a client
+func (d syncDefinition) public() syncDefinition {
+	d.RefreshToken = ""
+	return d
+}
+
+// syncStore keeps sync definitions in memory and saves them to a JSON file after every change.
+// The file holds refresh tokens so only the app's user can read it.
+type syncStore struct {
+	mu    sync.Mutex
+	path  string
+	syncs map[string]*syncDefinition
+}
+
+// newSyncStore loads the syncs saved at path. A missing file is an empty store.
+func newSyncStore(path string) *syncStore {
+	s := &syncStore{path: path, syncs: make(map[string]*syncDefinition)}
+
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return s
+	}
+	if err != nil {
+		logSyncError("Could not read the syncs", err)
+		return s
+	}
+
+	var saved []*syncDefinition
+	if err := json.Unmarshal(data, &saved); err != nil {
+		logSyncError("Could not read the syncs", err)
+		return s
+	}
+	for _, d := range saved {
+		s.syncs[d.ID] = d
+	}
+	return s
+}
+
+// save writes every sync to the store's file. s.mu must be held. The syncs are written to a
+// temporary file first so a crash never leaves a partly written store.
+func (s *syncStore) save() error {
+	saved := make([]*syncDefinition, 0, len(s.syncs))
+	for _, d := range s.syncs {
+		saved = append(saved, d)
+	}
+	data, err := json.MarshalIndent(saved, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(s.path), 0700); err != nil {
+		return err
+	}
+	tmp, err := ioutil.TempFile(filepath.Dir(s.path), filepath.Base(s.path)+".tmp")
+	if err != nil {
+		return err
+	}
+	_, err = tmp.Write(data)
+	tmp.Close()
+	if err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return os.Rename(tmp.Name(), s.path)
+}
+
+func (s *syncStore) add(d *syncDefinition) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.syncs[d.ID] = d
+	return s.save()
+}
+
+// get gets a copy of the sync with the id
+func (s *syncStore) get(id string) (syncDefinition, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	d, ok := s.syncs[id]
+	if !ok {
+		return syncDefinition{}, false
+	}
+	return *d, true
+}
+
+func (s *syncStore) remove(id string) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.syncs[id]; !ok {
+		return false, nil
+	}
+	delete(s.syncs, id)
+	return true, s.save()
+}
+
+// due gets copies of the syncs that should run now and marks them as running so they
+// aren't started again before they finish
+func (s *syncStore) due(now time.Time) []syncDefinition {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var due []syncDefinition
+	for _, d := range s.syncs {
+		if !d.running && !now.Before(d.NextRun) {
+			d.running = true
+			due = append(due, *d)
+		}
+	}
+	return due
+}
+
+// finish records a run of the sync and schedules the next one. The refresh token and target
+// playlist are updated as Spotify may issue a new token and the first run creates the playlist.
+func (s *syncStore) finish(ran syncDefinition, run syncRun) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	d, ok := s.syncs[ran.ID]
+	if !ok {
+		// The sync was deleted while it ran
+		return nil
+	}
+
+	d.running = false
+	d.RefreshToken = ran.RefreshToken
+	d.Port.TargetPlaylistID = ran.Port.TargetPlaylistID
+	d.Port.TargetPlaylistName = ran.Port.TargetPlaylistName
+	d.NextRun = run.Started.Add(cadences[d.Cadence])
+	d.Runs = append(d.Runs, run)
+	if len(d.Runs) > maxRuns {
+		d.Runs = d.Runs[len(d.Runs)-maxRuns:]
+	}
+	return s.save()
+}
+
+func newSyncID() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// validate checks the sync request, returning an error with a message for the user if it isn't valid
+func (req syncRequest) validate() error {
+	if _, ok := cadences[req.Cadence]; !ok {
+		return errors.New("Choose how often to sync: hourly, daily or weekly")
+	}
+	// A sync replaces the tracks it added last time, which can't be done with Liked Songs
+	if req.liked() {
+		return errors.New("Only playlists can be synced, not Liked Songs")
+	}
+	// Recent tracks are for fixed dates so syncing them would add the same tracks every time
+	if req.source() == sourceRecent {
+		return errors.New("Only top tracks and loved tracks can be synced")
+	}
+	return req.portPlaylistData.validate()
+}
+
+// CreateSyncHandler creates a sync that keeps a playlist in step with the user's Last.fm top
+// tracks. The user's refresh token is kept so the sync can run without them. The first run
+// happens straight away.
+func CreateSyncHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	var req syncRequest
+
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		http.Error(w, "Malformed JSON", http.StatusBadRequest)
+		return
+	}
+
+	if err = req.validate(); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	spotifyAuthDetails := r.Context().Value(middleware.AuthCxtKey).(spotify.AuthDetails)
+	if spotifyAuthDetails.RefreshToken == "" {
+		http.Error(w, "Log in to Spotify again to set up a sync", http.StatusBadRequest)
+		return
+	}
+
+	userID, err := spotify.GetUserID(&spotifyAuthDetails)
+	if err != nil {
+		http.Error(w, "Could not get Spotify user info", http.StatusInternalServerError)
+		return
+	}
+
+	id, err := newSyncID()
+	if err != nil {
+		http.Error(w, "Could not create the sync. Try again or contact me.", http.StatusInternalServerError)
+		return
+	}
+
+	now := time.Now()
+	d := &syncDefinition{
+		ID:            id,
+		Port:          req.portPlaylistData,
+		Cadence:       req.Cadence,
+		SpotifyUserID: userID,
+		RefreshToken:  spotifyAuthDetails.RefreshToken,
+		Created:       now,
+		NextRun:       now,
+		Runs:          []syncRun{},
+	}
+	if err = syncs.add(d); err != nil {
+		logSyncError("Could not save the syncs", err)
+		http.Error(w, "Could not create the sync. Try again or contact me.", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Location", syncsPath+id)
+	writeSync(w, http.StatusCreated, d.public())
+}
+
+// SyncHandler returns a sync with its recent runs at POST /syncs/{id}/status and deletes it at
+// DELETE /syncs/{id}. Only the Spotify user who created the sync can see or delete it, so like the
+// other Spotify routes the request body holds the user's auth details, which is why the status is
+// read with POST rather than GET. Other users are told the sync doesn't exist so sync IDs can't be probed.
+func SyncHandler(w http.ResponseWriter, r *http.Request) {
+	parts := strings.Split(strings.TrimPrefix(r.URL.Path, syncsPath), "/")
+	id := parts[0]
+	status := len(parts) == 2 && parts[1] == "status"
+	if id == "" || len(parts) > 2 || (len(parts) == 2 && !status) {
+		http.NotFound(w, r)
+		return
+	}
+	if (status && r.Method != "POST") || (!status && r.Method != "DELETE") {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	spotifyAuthDetails := r.Context().Value(middleware.AuthCxtKey).(spotify.AuthDetails)
+	userID, err := spotify.GetUserID(&spotifyAuthDetails)
+	if err != nil {
+		http.Error(w, "Could not get Spotify user info", http.StatusInternalServerError)
+		return
+	}
+	if userID == "" {
+		http.Error(w, "Log in to Spotify again to manage your syncs", http.StatusUnauthorized)
+		return
+	}
+
+	d, ok := syncs.get(id)
+	if !ok || d.SpotifyUserID != userID {
+		http.Error(w, "This sync doesn't exist", http.StatusNotFound)
+		return
+	}
+
+	if status {
+		writeSync(w, http.StatusOK, d.public())
+		return
+	}
+
+	ok, err = syncs.remove(id)
+	if err != nil {
+		logSyncError("Could not save the syncs", err)
+		http.Error(w, "Could not delete the sync. Try again or contact me.", http.StatusInternalServerError)
+		return
+	}
+	if !ok {
+		http.Error(w, "This sync doesn't exist", http.StatusNotFound)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func writeSync(w http.ResponseWriter, status int, d syncDefinition) {
+	jsonValue, err := json.Marshal(d)
+	if err != nil {
+		http.Error(w, "Could not get the sync", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-type", "application/json")
+	w.WriteHeader(status)
+	w.Write(jsonValue)
+}
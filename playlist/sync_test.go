@@ -0,0 +1,116 @@
+package playlist
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/conorbros/las-tools/conf"
+	"github.com/conorbros/las-tools/middleware"
+	"github.com/conorbros/las-tools/util"
+)
+
+func tempSyncStore(t *testing.T) (*syncStore, func()) {
+	dir, err := ioutil.TempDir("", "syncs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return newSyncStore(filepath.Join(dir, "syncs.json")), func() { os.RemoveAll(dir) }
+}
+
+func TestSyncStoreRunsDueSyncs(t *testing.T) {
+	s, cleanup := tempSyncStore(t)
+	defer cleanup()
+
+	now := time.Now()
+	s.add(&syncDefinition{ID: "due", Cadence: "daily", NextRun: now.Add(-time.Minute), RefreshToken: "old"})
+	s.add(&syncDefinition{ID: "later", Cadence: "daily", NextRun: now.Add(time.Hour)})
+
+	due := s.due(now)
+	if len(due) != 1 || due[0].ID != "due" {
+		t.Fatalf("due = %+v; want only the due sync", due)
+	}
+	if again := s.due(now); len(again) != 0 {
+		t.Errorf("a running sync was due again")
+	}
+
+	ran := due[0]
+	ran.RefreshToken = "new"
+	ran.Port.TargetPlaylistID = "playlist"
+	if err := s.finish(ran, syncRun{Started: now, Status: runSucceeded}); err != nil {
+		t.Fatal(err)
+	}
+
+	// A new store with the same file, as after a restart
+	reloaded := newSyncStore(s.path)
+	d, ok := reloaded.get("due")
+	if !ok {
+		t.Fatal("sync was not saved")
+	}
+	if d.RefreshToken != "new" || d.Port.TargetPlaylistID != "playlist" || len(d.Runs) != 1 {
+		t.Errorf("saved sync = %+v; want the run, new token and playlist recorded", d)
+	}
+	if !d.NextRun.Equal(now.Add(24 * time.Hour)) {
+		t.Errorf("next run = %v; want a day after the run started", d.NextRun)
+	}
+}
+
+// serveSync makes a request to the sync handler through middleware.SpotifyAuthRequired with
+// auth details for the access token in the body, as a browser would send them
+func serveSync(method, path, accessToken string) *httptest.ResponseRecorder {
+	body := fmt.Sprintf(`{"access_token":%q,"expires_in":3600,"time_obtained":%d}`, accessToken, util.EpochUTC())
+	w := httptest.NewRecorder()
+	middleware.SpotifyAuthRequired(http.HandlerFunc(SyncHandler)).ServeHTTP(w, httptest.NewRequest(method, path, strings.NewReader(body)))
+	return w
+}
+
+func TestSyncHandler(t *testing.T) {
+	store, cleanup := tempSyncStore(t)
+	defer cleanup()
+	saved := syncs
+	syncs = store
+	defer func() { syncs = saved }()
+
+	// The user ID is the access token after "Bearer "
+	users := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, `{"id":%q}`, strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer "))
+	}))
+	defer users.Close()
+	conf.Config.Spotify.UserInfoEndpoint = users.URL
+
+	syncs.add(&syncDefinition{ID: "abc", Cadence: "weekly", SpotifyUserID: "owner", RefreshToken: "secret"})
+
+	// Other users can't see or delete the sync
+	if w := serveSync("POST", syncsPath+"abc/status", "someone-else"); w.Code != http.StatusNotFound {
+		t.Errorf("status by another user = %d; want %d", w.Code, http.StatusNotFound)
+	}
+	if w := serveSync("DELETE", syncsPath+"abc", "someone-else"); w.Code != http.StatusNotFound {
+		t.Errorf("DELETE by another user status = %d; want %d", w.Code, http.StatusNotFound)
+	}
+
+	if w := serveSync("GET", syncsPath+"abc/status", "owner"); w.Code != http.StatusMethodNotAllowed {
+		t.Errorf("GET status = %d; want %d", w.Code, http.StatusMethodNotAllowed)
+	}
+
+	w := serveSync("POST", syncsPath+"abc/status", "owner")
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d; want %d", w.Code, http.StatusOK)
+	}
+	if body := w.Body.String(); body == "" || strings.Contains(body, "secret") {
+		t.Errorf("status response %s; want the sync without its refresh token", body)
+	}
+
+	if w := serveSync("DELETE", syncsPath+"abc", "owner"); w.Code != http.StatusNoContent {
+		t.Errorf("DELETE status = %d; want %d", w.Code, http.StatusNoContent)
+	}
+
+	if w := serveSync("POST", syncsPath+"abc/status", "owner"); w.Code != http.StatusNotFound {
+		t.Errorf("status after DELETE = %d; want %d", w.Code, http.StatusNotFound)
+	}
+}
@@ -0,0 +1,114 @@
+package spotify
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+
+	"github.com/conorbros/las-tools/conf"
+)
+
+// maxSavedTracksPerRequest is the most tracks Spotify accepts in one request to save or check
+// tracks in the user's library
+const maxSavedTracksPerRequest = 50
+
+// TrackURIPrefix starts the URI of every Spotify track
+const TrackURIPrefix = "spotify:track:"
+
+// SaveTracks saves the tracks to the user's Liked Songs. Tracks that are already saved are skipped
+// so they keep the date they were first liked. The tracks are saved in chunks of up to 50, and
+// chunks that can't be saved are returned in an *AddTracksError once the rest have been saved.
+// The number of tracks saved, the tracks that weren't found on Spotify and the number that were
+// already saved are returned. A track matched more than once is only saved and counted once.
+func SaveTracks(tracks []Track, authDetails *AuthDetails) (saved int, tracksNotFound []Track, alreadySaved int, err error) {
+	var found []Track
+	seen := make(map[string]bool)
+	for _, t := range tracks {
+		if t.SpotifyURI == "" {
+			tracksNotFound = append(tracksNotFound, t)
+			continue
+		}
+		// The same track can be matched more than once and only needs saving once
+		if seen[t.SpotifyURI] {
+			continue
+		}
+		seen[t.SpotifyURI] = true
+		found = append(found, t)
+	}
+
+	addErr := &AddTracksError{}
+	for start := 0; start < len(found); start += maxSavedTracksPerRequest {
+		end := start + maxSavedTracksPerRequest
+		if end > len(found) {
+			end = len(found)
+		}
+		chunk := found[start:end]
+
+		inLibrary, err := savedTracks(chunk, authDetails)
+		if err != nil {
+			addErr.Chunks = append(addErr.Chunks, ChunkError{Start: start, Tracks: chunk, Err: err.Error()})
+			continue
+		}
+
+		var ids []string
+		for i, t := range chunk {
+			if inLibrary[i] {
+				alreadySaved++
+				continue
+			}
+			ids = append(ids, trackID(t.SpotifyURI))
+		}
+		if len(ids) == 0 {
+			continue
+		}
+
+		if err := sendChunk(http.MethodPut, conf.Config.Spotify.SavedTracksEndpoint, map[string][]string{"ids": ids}, authDetails); err != nil {
+			addErr.Chunks = append(addErr.Chunks, ChunkError{Start: start, Tracks: chunk, Err: err.Error()})
+			continue
+		}
+		addErr.Added += len(ids)
+	}
+
+	if len(addErr.Chunks) > 0 {
+		return addErr.Added, tracksNotFound, alreadySaved, addErr
+	}
+	return addErr.Added, tracksNotFound, alreadySaved, nil
+}
+
+// savedTracks checks whether each of the tracks is already in the user's Liked Songs
+func savedTracks(tracks []Track, authDetails *AuthDetails) ([]bool, error) {
+	ids := make([]string, len(tracks))
+	for i, t := range tracks {
+		ids[i] = trackID(t.SpotifyURI)
+	}
+
+	res, err := getRateLimited(conf.Config.Spotify.ContainsSavedTracksEndpoint+"?ids="+strings.Join(ids, ","), "Bearer "+authDetails.AccessToken)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+
+	body, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		return nil, err
+	}
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("Could not check the user's saved tracks: status %d", res.StatusCode)
+	}
+
+	var saved []bool
+	if err = json.Unmarshal(body, &saved); err != nil {
+		return nil, err
+	}
+	if len(saved) != len(ids) {
+		return nil, fmt.Errorf("Spotify checked %d saved tracks; want %d", len(saved), len(ids))
+	}
+	return saved, nil
+}
+
+// trackID gets the ID of a track from its URI
+func trackID(uri string) string {
+	return strings.TrimPrefix(uri, TrackURIPrefix)
+}
@@ -0,0 +1,109 @@
+package spotify
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// SearchConcurrency is the number of Spotify searches made at once when matching tracks or finding
+// chart images, kept low so concurrent searches don't keep hitting the rate limit
+const SearchConcurrency = 8
+
+// maxRateLimitRetries is how many times a request is retried after Spotify rate limits it
+const maxRateLimitRetries = 5
+
+// defaultRetryAfter is how long to wait when a rate limited response has no usable Retry-After header
+const defaultRetryAfter = time.Second
+
+// errRateLimited is returned when a request is still rate limited after every retry
+var errRateLimited = errors.New("Spotify rate limit exceeded")
+
+// rateLimit pauses every request to the Spotify API once any of them is rate limited, so
+// concurrent searches back off together instead of each hitting the limit in turn
+type rateLimit struct {
+	mu          sync.Mutex
+	pausedUntil time.Time
+}
+
+var limiter rateLimit
+
+// pause stops requests from being made until d has passed. A shorter pause never
+// cuts an earlier, longer one short.
+func (l *rateLimit) pause(d time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	until := time.Now().Add(d)
+	if until.After(l.pausedUntil) {
+		l.pausedUntil = until
+	}
+}
+
+// wait blocks until requests are no longer paused
+func (l *rateLimit) wait() {
+	for {
+		l.mu.Lock()
+		d := time.Until(l.pausedUntil)
+		l.mu.Unlock()
+
+		if d <= 0 {
+			return
+		}
+		time.Sleep(d)
+	}
+}
+
+// retryAfter gets how long Spotify asked us to wait from the Retry-After header, which is in seconds
+func retryAfter(res *http.Response) time.Duration {
+	seconds, err := strconv.Atoi(res.Header.Get("Retry-After"))
+	if err != nil || seconds < 0 {
+		return defaultRetryAfter
+	}
+	return time.Duration(seconds) * time.Second
+}
+
+// getRateLimited makes a GET request to a Spotify API endpoint, retrying it if it is rate limited
+func getRateLimited(endpoint string, authorization string) (*http.Response, error) {
+	return doRateLimited(func() (*http.Request, error) {
+		req, err := http.NewRequest(http.MethodGet, endpoint, nil)
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Add("Authorization", authorization)
+		return req, nil
+	})
+}
+
+// doRateLimited makes the request built by newRequest. When Spotify responds with 429 Too Many
+// Requests all requests are paused for as long as it asks before a new request is built and retried.
+func doRateLimited(newRequest func() (*http.Request, error)) (*http.Response, error) {
+	client := http.Client{
+		Timeout: time.Duration(5 * time.Second),
+	}
+
+	for attempt := 0; ; attempt++ {
+		limiter.wait()
+
+		req, err := newRequest()
+		if err != nil {
+			return nil, err
+		}
+
+		res, err := client.Do(req)
+		if err != nil {
+			return nil, err
+		}
+		if res.StatusCode != http.StatusTooManyRequests {
+			return res, nil
+		}
+
+		res.Body.Close()
+		if attempt >= maxRateLimitRetries {
+			return nil, errRateLimited
+		}
+		limiter.pause(retryAfter(res))
+	}
+}
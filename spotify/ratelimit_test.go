@@ -0,0 +1,56 @@
+package spotify
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestGetRateLimitedRetries(t *testing.T) {
+	var hits int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&hits, 1) <= 2 {
+			w.Header().Set("Retry-After", "0")
+			w.WriteHeader(http.StatusTooManyRequests)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	res, err := getRateLimited(server.URL, "Bearer token")
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		t.Errorf("status = %d; want %d", res.StatusCode, http.StatusOK)
+	}
+	if hits != 3 {
+		t.Errorf("server was hit %d times; want 3", hits)
+	}
+}
+
+func TestGetRateLimitedGivesUp(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Retry-After", "0")
+		w.WriteHeader(http.StatusTooManyRequests)
+	}))
+	defer server.Close()
+
+	if _, err := getRateLimited(server.URL, "Bearer token"); err != errRateLimited {
+		t.Errorf("err = %v; want %v", err, errRateLimited)
+	}
+}
+
+func TestRateLimitPauseKeepsLongest(t *testing.T) {
+	var l rateLimit
+	l.pause(time.Hour)
+	l.pause(time.Millisecond)
+
+	if time.Until(l.pausedUntil) < 59*time.Minute {
+		t.Error("a shorter pause cut the longer one short")
+	}
+}
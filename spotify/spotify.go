@@ -10,6 +10,7 @@ import (
 	"net/http"
 	"net/url"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/conorbros/las-tools/conf"
@@ -27,10 +28,46 @@ type AuthDetails struct {
 
 type trackURIResponse struct {
 	Tracks struct {
+		Items []Candidate `json:"items"`
+	} `json:"tracks"`
+}
+
+// Candidate represents a track found with the Spotify search API that may match a Last.fm track
+type Candidate struct {
+	URI        string   `json:"uri"`
+	Name       string   `json:"name"`
+	Artists    []Artist `json:"artists"`
+	DurationMs int      `json:"duration_ms"`
+	Album      Album    `json:"album"`
+}
+
+// Artist represents an artist credited on a track found with the Spotify search API
+type Artist struct {
+	Name string `json:"name"`
+}
+
+// Album represents the album a track found with the Spotify search API appears on
+type Album struct {
+	ID        string  `json:"id"`
+	Name      string  `json:"name"`
+	AlbumType string  `json:"album_type"`
+	Images    []Image `json:"images"`
+}
+
+type artistSearchResponse struct {
+	Artists struct {
 		Items []struct {
-			URI string `json:"uri"`
+			Name   string  `json:"name"`
+			Images []Image `json:"images"`
 		} `json:"items"`
-	} `json:"tracks"`
+	} `json:"artists"`
+}
+
+// Image represents an image of an artist or album returned by the Spotify API
+type Image struct {
+	URL    string `json:"url"`
+	Height int    `json:"height"`
+	Width  int    `json:"width"`
 }
 
 // User represents the response from the get user info endpoint of the Spotify API
@@ -41,8 +78,26 @@ type User struct {
 
 // Playlist represents the createed playlist object returned after creating a playlist
 type Playlist struct {
-	ID  string `json:"id"`
-	URI string `json:"uri"`
+	ID            string `json:"id"`
+	URI           string `json:"uri"`
+	Name          string `json:"name"`
+	Collaborative bool   `json:"collaborative"`
+	Owner         struct {
+		ID string `json:"id"`
+	} `json:"owner"`
+}
+
+type playlistsResponse struct {
+	Items []Playlist `json:"items"`
+	Next  string     `json:"next"`
+}
+
+// PlaylistDetails are the settings of a new playlist
+type PlaylistDetails struct {
+	Name          string `json:"name"`
+	Description   string `json:"description"`
+	Public        bool   `json:"public"`
+	Collaborative bool   `json:"collaborative"`
 }
 
 // Track represents the a track to be added to the user's new spotify playlist
@@ -50,6 +105,12 @@ type Track struct {
 	Artist     string
 	Title      string
 	SpotifyURI string
+	// Duration is the length of the track in seconds according to Last.fm, or 0 if it isn't known
+	Duration int
+	// Score is how confident we are that SpotifyURI is the right track, from 0 to 1
+	Score float64
+	// Strategy is the name of the search strategy that found the track on Spotify
+	Strategy string
 }
 
 func getClientIDClientSecretHeader() string {
@@ -113,8 +174,31 @@ func GetUserAccessTokenHandler(w http.ResponseWriter, r *http.Request) {
 	w.Write(body)
 }
 
-// GetClientAccessToken gets an access token for reading public data
+// clientTokenMargin is how long before it expires a client access token is replaced, so a
+// token isn't used just as it runs out
+const clientTokenMargin = time.Minute
+
+// ErrTokenExpired is returned when Spotify rejects an access token, usually because it has
+// expired. Client access tokens are replaced the next time GetClientAccessToken is called.
+var ErrTokenExpired = errors.New("Spotify access token expired")
+
+// clientToken is the client access token shared by every search until it is about to expire
+var clientToken struct {
+	mu      sync.Mutex
+	token   string
+	expires time.Time
+}
+
+// GetClientAccessToken gets an access token for reading public data. The token is reused until
+// shortly before it expires, so long running ports can call this before each search.
 func GetClientAccessToken() (string, error) {
+	clientToken.mu.Lock()
+	defer clientToken.mu.Unlock()
+
+	if clientToken.token != "" && time.Now().Before(clientToken.expires) {
+		return clientToken.token, nil
+	}
+
 	reqBody := url.Values{"grant_type": {"client_credentials"}}
 	req, err := http.NewRequest(http.MethodPost, conf.Config.Spotify.TokenEndpoint, strings.NewReader(reqBody.Encode()))
 	if err != nil {
@@ -146,7 +230,40 @@ func GetClientAccessToken() (string, error) {
 	if !ok {
 		return "", nil
 	}
-	return "Bearer " + accessToken, nil
+	expiresIn, _ := data["expires_in"].(float64)
+
+	clientToken.token = "Bearer " + accessToken
+	clientToken.expires = time.Now().Add(time.Duration(expiresIn)*time.Second - clientTokenMargin)
+	return clientToken.token, nil
+}
+
+// expireClientAccessToken stops token from being reused. A token that has already been replaced
+// is left alone.
+func expireClientAccessToken(token string) {
+	clientToken.mu.Lock()
+	defer clientToken.mu.Unlock()
+
+	if clientToken.token == token {
+		clientToken.token = ""
+	}
+}
+
+// WithClientToken calls search with the current client access token. Ports and charts can run for
+// longer than a token lasts, so if the token expires during the search it is run again with a new one.
+func WithClientToken(search func(clientAccessToken string) error) error {
+	clientAccessToken, err := GetClientAccessToken()
+	if err != nil {
+		return err
+	}
+	err = search(clientAccessToken)
+	if err != ErrTokenExpired {
+		return err
+	}
+
+	if clientAccessToken, err = GetClientAccessToken(); err != nil {
+		return err
+	}
+	return search(clientAccessToken)
 }
 
 // RefreshAuth refreshes spotify auth details using the refresh token
@@ -184,45 +301,101 @@ func RefreshAuth(spotifyAuthDetails *AuthDetails) error {
 	return nil
 }
 
-// GetTrackSpotifyURI gets the spotify URI for a track matching the given artist and title
-// https://api.spotify.com/v1/search?type=track&limit=10&q=Death+in+Vegas+-+Girls
-func GetTrackSpotifyURI(artist string, title string, clientAccessToken string) (string, error) {
-	var endpoint = conf.Config.Spotify.SearchEndpoint + "?type=track&limit=10&q="
-	endpoint = fmt.Sprintf(endpoint+"artist:%s+track:%s", url.QueryEscape(artist), url.QueryEscape(title))
+// SearchTracksQuery gets the tracks matching a free text query, in the order Spotify ranks them
+func SearchTracksQuery(query string, clientAccessToken string) ([]Candidate, error) {
+	response, err := searchTrackQuery(query, clientAccessToken)
+	if err != nil {
+		return nil, err
+	}
+	return response.Tracks.Items, nil
+}
 
-	req, err := http.NewRequest(http.MethodGet, endpoint, strings.NewReader(""))
+// GetTrackAlbum gets the album of the first track matching the given artist and title.
+// The returned album has an empty ID if no track was found.
+func GetTrackAlbum(artist string, title string, clientAccessToken string) (Album, error) {
+	response, err := searchTrack(artist, title, clientAccessToken)
 	if err != nil {
-		return "", err
+		return Album{}, err
 	}
-	req.Header.Add("Authorization", clientAccessToken)
 
-	client := http.Client{
-		Timeout: time.Duration(5 * time.Second),
+	if len(response.Tracks.Items) == 0 {
+		return Album{}, nil
 	}
-	res, err := client.Do(req)
+	return response.Tracks.Items[0].Album, nil
+}
+
+// searchTrack searches Spotify for tracks matching the given artist and title
+func searchTrack(artist string, title string, clientAccessToken string) (trackURIResponse, error) {
+	return searchTrackQuery(fmt.Sprintf("artist:%s track:%s", artist, title), clientAccessToken)
+}
+
+// searchTrackQuery searches Spotify for tracks with a query in Spotify's search syntax
+func searchTrackQuery(query string, clientAccessToken string) (trackURIResponse, error) {
+	var response trackURIResponse
+
+	endpoint := conf.Config.Spotify.SearchEndpoint + "?type=track&limit=10&q=" + url.QueryEscape(query)
+
+	res, err := getRateLimited(endpoint, clientAccessToken)
 	if err != nil {
-		return "", err
+		return response, err
 	}
 
 	defer res.Body.Close()
 	body, err := ioutil.ReadAll(res.Body)
 	if err != nil {
-		return "", err
+		return response, err
+	}
+	if res.StatusCode == http.StatusUnauthorized {
+		expireClientAccessToken(clientAccessToken)
+		return response, ErrTokenExpired
+	}
+	if res.StatusCode != http.StatusOK {
+		return response, fmt.Errorf("Spotify search failed: status %d", res.StatusCode)
 	}
 
-	var response trackURIResponse
 	err = json.Unmarshal(body, &response)
+	return response, err
+}
+
+// GetArtistImages gets the images for the artist matching the given name, largest first.
+// An artist whose name matches exactly is preferred over the top search result.
+func GetArtistImages(artist string, clientAccessToken string) ([]Image, error) {
+	endpoint := conf.Config.Spotify.SearchEndpoint + "?type=artist&limit=5&q=" + url.QueryEscape("artist:"+artist)
+
+	res, err := getRateLimited(endpoint, clientAccessToken)
 	if err != nil {
-		return "", err
+		return nil, err
 	}
 
-	if len(response.Tracks.Items) == 0 {
-		return "", nil
+	defer res.Body.Close()
+	body, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		return nil, err
+	}
+	if res.StatusCode == http.StatusUnauthorized {
+		expireClientAccessToken(clientAccessToken)
+		return nil, ErrTokenExpired
+	}
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("Spotify artist search failed: status %d", res.StatusCode)
+	}
+
+	var response artistSearchResponse
+	err = json.Unmarshal(body, &response)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(response.Artists.Items) == 0 {
+		return nil, nil
 	}
 
-	// Select the first (or only) result as the URI to use
-	uri := response.Tracks.Items[0].URI
-	return uri, nil
+	for _, a := range response.Artists.Items {
+		if strings.EqualFold(a.Name, artist) {
+			return a.Images, nil
+		}
+	}
+	return response.Artists.Items[0].Images, nil
 }
 
 // GetUserID returns the user id for the auth details of the logged in user
@@ -257,11 +430,13 @@ func GetUserID(authDetails *AuthDetails) (string, error) {
 }
 
 // CreatePlaylist creates a playlist on the user account supplied
-func CreatePlaylist(userID string, authDetails *AuthDetails) (Playlist, error) {
+func CreatePlaylist(userID string, details PlaylistDetails, authDetails *AuthDetails) (Playlist, error) {
 	var playlist Playlist
 
-	values := map[string]string{"name": "Lastools Playlist", "description": "This playlist was generated automatically with conorb.dev lastools"}
-	jsonValue, err := json.Marshal(values)
+	jsonValue, err := json.Marshal(details)
+	if err != nil {
+		return playlist, err
+	}
 
 	req, err := http.NewRequest(http.MethodPost, strings.ReplaceAll(conf.Config.Spotify.UserPlaylistEndpoint, "{user_id}", userID), bytes.NewBuffer(jsonValue))
 	if err != nil {
@@ -292,9 +467,87 @@ func CreatePlaylist(userID string, authDetails *AuthDetails) (Playlist, error) {
 	return playlist, nil
 }
 
-// AddTracksToPlaylist adds the tracks to the supplied playlist
+// FindPlaylistByName finds a playlist the user can change with the given name, looking through
+// every page of the user's playlists. The name is matched ignoring case. ok is false if there
+// is no such playlist.
+func FindPlaylistByName(userID string, name string, authDetails *AuthDetails) (playlist Playlist, ok bool, err error) {
+	endpoint := conf.Config.Spotify.CurrentUserPlaylistsEndpoint + "?limit=50"
+	for endpoint != "" {
+		res, err := getRateLimited(endpoint, "Bearer "+authDetails.AccessToken)
+		if err != nil {
+			return Playlist{}, false, err
+		}
+
+		body, err := ioutil.ReadAll(res.Body)
+		res.Body.Close()
+		if err != nil {
+			return Playlist{}, false, err
+		}
+		if res.StatusCode != http.StatusOK {
+			return Playlist{}, false, fmt.Errorf("Could not get the user's playlists: status %d", res.StatusCode)
+		}
+
+		var page playlistsResponse
+		if err = json.Unmarshal(body, &page); err != nil {
+			return Playlist{}, false, err
+		}
+
+		for _, p := range page.Items {
+			if strings.EqualFold(p.Name, name) && (p.Owner.ID == userID || p.Collaborative) {
+				return p, true, nil
+			}
+		}
+		endpoint = page.Next
+	}
+	return Playlist{}, false, nil
+}
+
+// maxTracksPerRequest is the most tracks Spotify accepts in one request to add tracks to a playlist
+const maxTracksPerRequest = 100
+
+// chunkRetries is how many times a PUT of a chunk of tracks is retried after it fails
+const chunkRetries = 2
+
+// chunkRetryDelay is how long to wait before retrying a chunk, multiplied by the attempt
+var chunkRetryDelay = time.Second
+
+// ChunkError is a chunk of tracks that could not be added to a playlist
+type ChunkError struct {
+	// Start is the position in the playlist the chunk would have started at
+	Start  int     `json:"start"`
+	Tracks []Track `json:"tracks"`
+	Err    string  `json:"error"`
+}
+
+// AddTracksError is returned when some chunks of tracks could not be added to a playlist.
+// The other chunks were added.
+type AddTracksError struct {
+	Chunks []ChunkError
+	// Added is the number of tracks that were added
+	Added int
+}
+
+func (e *AddTracksError) Error() string {
+	return fmt.Sprintf("%d chunks of tracks were not added to the playlist", len(e.Chunks))
+}
+
+// AddTracksToPlaylist adds the tracks to the supplied playlist. The tracks are added in order in chunks
+// of up to 100, which is the most Spotify allows at once. Chunks that fail are retried, and chunks
+// that still fail are skipped and returned in an *AddTracksError once the rest have been added.
 func AddTracksToPlaylist(playlist Playlist, tracks []Track, authDetails *AuthDetails) ([]Track, error) {
-	var trackURIs []string
+	return addTracks(playlist, tracks, authDetails, false)
+}
+
+// ReplacePlaylistTracks replaces the contents of the playlist with the tracks, keeping the playlist
+// itself so its followers and link stay the same. The first chunk replaces the old tracks and the
+// rest are added after it like AddTracksToPlaylist. If the first chunk fails the playlist is left
+// as it was and the error is returned.
+func ReplacePlaylistTracks(playlist Playlist, tracks []Track, authDetails *AuthDetails) ([]Track, error) {
+	return addTracks(playlist, tracks, authDetails, true)
+}
+
+func addTracks(playlist Playlist, tracks []Track, authDetails *AuthDetails, replace bool) ([]Track, error) {
+	var found []Track
 	var tracksNotFound []Track
 
 	for _, t := range tracks {
@@ -302,34 +555,91 @@ func AddTracksToPlaylist(playlist Playlist, tracks []Track, authDetails *AuthDet
 			tracksNotFound = append(tracksNotFound, t)
 			continue
 		}
-		trackURIs = append(trackURIs, t.SpotifyURI)
+		found = append(found, t)
 	}
-	values := map[string][]string{"uris": trackURIs}
 
-	jsonValue, err := json.Marshal(values)
-	if err != nil {
-		return nil, err
+	if replace && len(found) == 0 {
+		// Clear the playlist
+		return tracksNotFound, addChunk(playlist, nil, authDetails, http.MethodPut)
 	}
 
-	req, err := http.NewRequest(http.MethodPost, strings.ReplaceAll(conf.Config.Spotify.AddItemsPlaylistEndpoint, "{playlist_id}", playlist.ID), bytes.NewBuffer(jsonValue))
+	addErr := &AddTracksError{}
+	for start := 0; start < len(found); start += maxTracksPerRequest {
+		end := start + maxTracksPerRequest
+		if end > len(found) {
+			end = len(found)
+		}
 
-	if err != nil {
-		return nil, err
+		method := http.MethodPost
+		if replace && start == 0 {
+			method = http.MethodPut
+		}
+
+		chunk := found[start:end]
+		if err := addChunk(playlist, chunk, authDetails, method); err != nil {
+			if method == http.MethodPut {
+				return tracksNotFound, err
+			}
+			addErr.Chunks = append(addErr.Chunks, ChunkError{Start: start, Tracks: chunk, Err: err.Error()})
+			continue
+		}
+		addErr.Added += len(chunk)
 	}
-	req.Header.Add("Authorization", "Bearer "+authDetails.AccessToken)
-	req.Header.Add("Content-type", "application/json")
 
-	client := http.Client{
-		Timeout: time.Duration(5 * time.Second),
+	if len(addErr.Chunks) > 0 {
+		return tracksNotFound, addErr
 	}
-	res, err := client.Do(req)
-	if err != nil {
-		return nil, err
+	return tracksNotFound, nil
+}
+
+// addChunk adds a chunk of tracks to the end of the playlist with POST, or replaces the playlist's
+// tracks with them with PUT, retrying the PUT when it fails
+func addChunk(playlist Playlist, tracks []Track, authDetails *AuthDetails, method string) error {
+	trackURIs := make([]string, len(tracks))
+	for i, t := range tracks {
+		trackURIs[i] = t.SpotifyURI
 	}
+	values := map[string][]string{"uris": trackURIs}
+
+	endpoint := strings.ReplaceAll(conf.Config.Spotify.AddItemsPlaylistEndpoint, "{playlist_id}", playlist.ID)
+	return sendChunk(method, endpoint, values, authDetails)
+}
 
-	if res.StatusCode != 201 {
-		return nil, errors.New("Tracks were not successfully added to playlist")
+// sendChunk sends a chunk of tracks to Spotify as JSON. A PUT is retried when the request fails or
+// Spotify responds with a server error. A POST isn't, as it may have added the tracks before failing
+// and adding them again would duplicate them in the playlist. Rate limiting is handled for both.
+func sendChunk(method string, endpoint string, values interface{}, authDetails *AuthDetails) error {
+	jsonValue, err := json.Marshal(values)
+	if err != nil {
+		return err
 	}
 
-	return tracksNotFound, nil
+	for attempt := 0; ; attempt++ {
+		res, err := doRateLimited(func() (*http.Request, error) {
+			req, err := http.NewRequest(method, endpoint, bytes.NewBuffer(jsonValue))
+			if err != nil {
+				return nil, err
+			}
+			req.Header.Add("Authorization", "Bearer "+authDetails.AccessToken)
+			req.Header.Add("Content-type", "application/json")
+			return req, nil
+		})
+		if err == nil {
+			res.Body.Close()
+			if res.StatusCode == http.StatusCreated || res.StatusCode == http.StatusOK {
+				return nil
+			}
+			err = fmt.Errorf("Tracks were not successfully added: status %d", res.StatusCode)
+
+			// Retrying won't fix a bad request
+			if res.StatusCode < 500 {
+				return err
+			}
+		}
+
+		if method != http.MethodPut || attempt >= chunkRetries {
+			return err
+		}
+		time.Sleep(chunkRetryDelay * time.Duration(attempt+1))
+	}
 }
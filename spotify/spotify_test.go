@@ -0,0 +1,303 @@
+package spotify
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/conorbros/las-tools/conf"
+)
+
+func TestAddTracksToPlaylistChunks(t *testing.T) {
+	chunkRetryDelay = 0
+
+	var added []string
+	var requests int
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		var body map[string][]string
+		json.NewDecoder(r.Body).Decode(&body)
+		if len(body["uris"]) > maxTracksPerRequest {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		// The second chunk always fails
+		if body["uris"][0] == "spotify:track:100" {
+			w.WriteHeader(http.StatusBadGateway)
+			return
+		}
+		added = append(added, body["uris"]...)
+		w.WriteHeader(http.StatusCreated)
+	}))
+	defer server.Close()
+	conf.Config.Spotify.AddItemsPlaylistEndpoint = server.URL + "/playlists/{playlist_id}/tracks"
+
+	tracks := make([]Track, 250)
+	for i := range tracks {
+		tracks[i].SpotifyURI = "spotify:track:" + strconv.Itoa(i)
+	}
+	tracks = append(tracks, Track{Artist: "Unknown", Title: "Not on Spotify"})
+
+	notFound, err := AddTracksToPlaylist(Playlist{ID: "p"}, tracks, &AuthDetails{AccessToken: "token"})
+	if len(notFound) != 1 {
+		t.Errorf("%d tracks not found; want 1", len(notFound))
+	}
+
+	addErr, ok := err.(*AddTracksError)
+	if !ok {
+		t.Fatalf("err = %v; want an *AddTracksError", err)
+	}
+	if len(addErr.Chunks) != 1 || addErr.Chunks[0].Start != 100 || len(addErr.Chunks[0].Tracks) != 100 {
+		t.Errorf("failed chunks = %+v; want the chunk starting at 100", addErr.Chunks)
+	}
+	if addErr.Added != 150 || len(added) != 150 {
+		t.Errorf("added %d tracks, reported %d; want 150", len(added), addErr.Added)
+	}
+	if added[0] != "spotify:track:0" || added[100] != "spotify:track:200" {
+		t.Errorf("tracks were added out of order: %s, %s", added[0], added[100])
+	}
+	// One request for each chunk as failed POSTs aren't retried in case they added the tracks
+	if requests != 3 {
+		t.Errorf("made %d requests; want 3", requests)
+	}
+}
+
+func TestSendChunkRetriesPUT(t *testing.T) {
+	chunkRetryDelay = 0
+
+	var requests int
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		// The first attempt fails
+		if requests == 1 {
+			w.WriteHeader(http.StatusBadGateway)
+			return
+		}
+		w.WriteHeader(http.StatusCreated)
+	}))
+	defer server.Close()
+
+	if err := sendChunk(http.MethodPut, server.URL, map[string][]string{"uris": {"spotify:track:0"}}, &AuthDetails{}); err != nil {
+		t.Errorf("PUT err = %v; want it to succeed when retried", err)
+	}
+	if requests != 2 {
+		t.Errorf("PUT made %d requests; want 2", requests)
+	}
+
+	requests = 0
+	if err := sendChunk(http.MethodPost, server.URL, map[string][]string{"uris": {"spotify:track:0"}}, &AuthDetails{}); err == nil {
+		t.Error("POST err = nil; want the failure returned without a retry")
+	}
+	if requests != 1 {
+		t.Errorf("POST made %d requests; want 1", requests)
+	}
+}
+
+func TestReplacePlaylistTracks(t *testing.T) {
+	var methods []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		methods = append(methods, r.Method)
+		w.WriteHeader(http.StatusCreated)
+	}))
+	defer server.Close()
+	conf.Config.Spotify.AddItemsPlaylistEndpoint = server.URL + "/playlists/{playlist_id}/tracks"
+
+	tracks := make([]Track, 150)
+	for i := range tracks {
+		tracks[i].SpotifyURI = "spotify:track:" + strconv.Itoa(i)
+	}
+	if _, err := ReplacePlaylistTracks(Playlist{ID: "p"}, tracks, &AuthDetails{}); err != nil {
+		t.Fatal(err)
+	}
+	if len(methods) != 2 || methods[0] != http.MethodPut || methods[1] != http.MethodPost {
+		t.Errorf("requests = %v; want PUT then POST", methods)
+	}
+
+	// Replacing with no tracks clears the playlist
+	methods = nil
+	if _, err := ReplacePlaylistTracks(Playlist{ID: "p"}, nil, &AuthDetails{}); err != nil {
+		t.Fatal(err)
+	}
+	if len(methods) != 1 || methods[0] != http.MethodPut {
+		t.Errorf("requests = %v; want one PUT", methods)
+	}
+}
+
+func TestFindPlaylistByName(t *testing.T) {
+	var server *httptest.Server
+	server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("offset") == "" {
+			fmt.Fprintf(w, `{"items":[{"id":"1","name":"Top Tracks","owner":{"id":"someone"}}],"next":%q}`, server.URL+"/?offset=50")
+			return
+		}
+		fmt.Fprint(w, `{"items":[{"id":"2","name":"Top Tracks","owner":{"id":"me"}}],"next":null}`)
+	}))
+	defer server.Close()
+	conf.Config.Spotify.CurrentUserPlaylistsEndpoint = server.URL + "/"
+
+	playlist, ok, err := FindPlaylistByName("me", "top tracks", &AuthDetails{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !ok || playlist.ID != "2" {
+		t.Errorf("FindPlaylistByName() = %q, %v; want the user's own playlist on the second page", playlist.ID, ok)
+	}
+
+	if _, ok, _ := FindPlaylistByName("me", "Missing", &AuthDetails{}); ok {
+		t.Error("FindPlaylistByName() found a playlist that doesn't exist")
+	}
+}
+
+func TestSaveTracksSkipsSavedTracks(t *testing.T) {
+	chunkRetryDelay = 0
+
+	var saved []string
+	var checks int
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodGet {
+			checks++
+			ids := strings.Split(r.URL.Query().Get("ids"), ",")
+			contains := make([]bool, len(ids))
+			for i, id := range ids {
+				// Tracks with an even ID are already saved
+				n, _ := strconv.Atoi(id)
+				contains[i] = n%2 == 0
+			}
+			json.NewEncoder(w).Encode(contains)
+			return
+		}
+
+		var body map[string][]string
+		json.NewDecoder(r.Body).Decode(&body)
+		if r.Method != http.MethodPut || len(body["ids"]) > maxSavedTracksPerRequest {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		saved = append(saved, body["ids"]...)
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+	conf.Config.Spotify.SavedTracksEndpoint = server.URL + "/me/tracks"
+	conf.Config.Spotify.ContainsSavedTracksEndpoint = server.URL + "/me/tracks/contains"
+
+	tracks := make([]Track, 120)
+	for i := range tracks {
+		tracks[i].SpotifyURI = "spotify:track:" + strconv.Itoa(i)
+	}
+	// A track matched twice is only saved once
+	tracks = append(tracks, Track{SpotifyURI: "spotify:track:1"}, Track{Artist: "Unknown", Title: "Not on Spotify"})
+
+	n, notFound, alreadySaved, err := SaveTracks(tracks, &AuthDetails{AccessToken: "token"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 60 {
+		t.Errorf("reported %d tracks saved; want 60", n)
+	}
+	if len(notFound) != 1 {
+		t.Errorf("%d tracks not found; want 1", len(notFound))
+	}
+	if alreadySaved != 60 || len(saved) != 60 {
+		t.Errorf("saved %d tracks, %d already saved; want 60 and 60", len(saved), alreadySaved)
+	}
+	if saved[0] != "1" || saved[59] != "119" {
+		t.Errorf("saved the wrong tracks: %s, %s", saved[0], saved[59])
+	}
+	if checks != 3 {
+		t.Errorf("checked saved tracks %d times; want 3", checks)
+	}
+}
+
+func TestClientAccessTokenIsReusedUntilRejected(t *testing.T) {
+	clientToken.token = ""
+
+	var issued int
+	tokens := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		issued++
+		fmt.Fprintf(w, `{"access_token":"t%d","expires_in":3600}`, issued)
+	}))
+	defer tokens.Close()
+	conf.Config.Spotify.TokenEndpoint = tokens.URL
+
+	// Searches with the first token are rejected as expired and any other token gets a server error
+	search := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Header.Get("Authorization") {
+		case "Bearer t1":
+			w.WriteHeader(http.StatusUnauthorized)
+		case "Bearer t2":
+			fmt.Fprint(w, `{"tracks":{"items":[{"uri":"spotify:track:1"}]}}`)
+		default:
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+	}))
+	defer search.Close()
+	conf.Config.Spotify.SearchEndpoint = search.URL
+
+	token, _ := GetClientAccessToken()
+	if again, _ := GetClientAccessToken(); again != token || issued != 1 {
+		t.Fatalf("got tokens %s and %s with %d requests; want the first token reused", token, again, issued)
+	}
+
+	if _, err := SearchTracksQuery("song", token); err != ErrTokenExpired {
+		t.Fatalf("search with a rejected token: err = %v; want ErrTokenExpired", err)
+	}
+
+	token, _ = GetClientAccessToken()
+	if token != "Bearer t2" {
+		t.Fatalf("token after rejection = %s; want a new token", token)
+	}
+	candidates, err := SearchTracksQuery("song", token)
+	if err != nil || len(candidates) != 1 {
+		t.Errorf("search with new token = %v, %v; want one candidate", candidates, err)
+	}
+
+	if _, err := SearchTracksQuery("song", "Bearer other"); err == nil {
+		t.Error("a failed search returned no error")
+	}
+}
+
+func TestGetArtistImagesRenewsExpiredToken(t *testing.T) {
+	clientToken.token = ""
+
+	var issued int
+	tokens := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		issued++
+		fmt.Fprintf(w, `{"access_token":"t%d","expires_in":3600}`, issued)
+	}))
+	defer tokens.Close()
+	conf.Config.Spotify.TokenEndpoint = tokens.URL
+
+	// The first token is rejected as expired and any token but the second gets a server error
+	search := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Header.Get("Authorization") {
+		case "Bearer t1":
+			w.WriteHeader(http.StatusUnauthorized)
+		case "Bearer t2":
+			fmt.Fprint(w, `{"artists":{"items":[{"name":"Other"},{"name":"Artist","images":[{"url":"a","width":300}]}]}}`)
+		default:
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+	}))
+	defer search.Close()
+	conf.Config.Spotify.SearchEndpoint = search.URL
+
+	var images []Image
+	err := WithClientToken(func(clientAccessToken string) (err error) {
+		images, err = GetArtistImages("artist", clientAccessToken)
+		return err
+	})
+	if err != nil || len(images) != 1 || images[0].URL != "a" {
+		t.Errorf("GetArtistImages() = %v, %v; want the exact match's image with a new token", images, err)
+	}
+	if issued != 2 {
+		t.Errorf("issued %d tokens; want 2", issued)
+	}
+
+	if _, err := GetArtistImages("artist", "Bearer other"); err == nil || err == ErrTokenExpired {
+		t.Errorf("GetArtistImages() after a server error: err = %v; want a search error", err)
+	}
+}
@@ -28,6 +28,17 @@ document
     let y = Number(xy[1]);
 
     const username = $("#username-textbox").val();
+    const period = $("#period-select").val();
+    const type = $("#type-select").val();
+    const order = $("#order-select").val();
+    const layout = $("#layout-select").val();
+    const colormode = $("#colormode-select").val();
+    const captions = $("#captions-select").val();
+    const playcounts = $("#playcounts-checkbox").is(":checked");
+    const format = $("#format-select").val();
+    const missing = $("#placeholder-checkbox").is(":checked")
+      ? "placeholder"
+      : "skip";
 
     if (!x || !y || !username) {
       return;
@@ -36,6 +47,19 @@ document
     url.searchParams.append("x", x);
     url.searchParams.append("y", y);
     url.searchParams.append("username", username);
+    url.searchParams.append("period", period);
+    url.searchParams.append("type", type);
+    url.searchParams.append("colormode", colormode);
+    url.searchParams.append("captions", captions);
+    url.searchParams.append("playcounts", playcounts);
+    url.searchParams.append("format", format);
+    url.searchParams.append("missing", missing);
+    if (order) {
+      url.searchParams.append("order", order);
+    }
+    if (layout) {
+      url.searchParams.append("layout", layout);
+    }
 
     loading();
 
@@ -39,22 +39,68 @@ function finishedLoading() {
 
 document.getElementById("port-button").addEventListener("click", () => {
   const lastFmUsername = document.getElementById("username-textbox").value;
+  const source = document.getElementById("source-select").value;
+  const destination = document.getElementById("destination-select").value;
   const songNumber = document.getElementById("song-number-select").value;
   const timePeriod = document.getElementById("time-period-select").value;
 
-  if (!lastFmUsername || !songNumber || !timePeriod) {
+  const fromDate = document.getElementById("from-date").value;
+  const toDate = document.getElementById("to-date").value;
+  const order = document.getElementById("order-select").value;
+
+  if (!lastFmUsername) {
+    return;
+  }
+  // Loved tracks have no time period and every one is ported unless a number is chosen
+  if (source === "top" && (!songNumber || !timePeriod)) {
+    return;
+  }
+  if (source === "recent" && (!fromDate || !toDate)) {
     return;
   }
 
+  const playlistName = document.getElementById("playlist-name-textbox").value;
+  const playlistDescription = document.getElementById(
+    "playlist-description-textbox"
+  ).value;
+  const collaborative = document.getElementById("collaborative-checkbox")
+    .checked;
+  // Spotify doesn't allow public collaborative playlists
+  const isPublic =
+    document.getElementById("public-checkbox").checked && !collaborative;
+
+  // A link or URI picks the playlist by its ID, anything else by its name
+  // Liked Songs isn't a playlist so there is nothing to update
+  const target =
+    destination === "liked"
+      ? ""
+      : document.getElementById("target-playlist-textbox").value.trim();
+  const targetIsID = /^(spotify:playlist:|https:\/\/open\.spotify\.com\/playlist\/)/.test(
+    target
+  );
+
   let data = addSpotifyTokens({
     lastFmUsername,
+    source,
+    destination,
     songNumber,
-    timePeriod,
+    timePeriod: source === "top" ? timePeriod : "",
+    // Recent listening runs from the start of the first day to the end of the last
+    from: source === "recent" ? unixTime(fromDate, "00:00:00") : 0,
+    to: source === "recent" ? unixTime(toDate, "23:59:59") : 0,
+    order,
+    playlistName,
+    playlistDescription,
+    public: isPublic,
+    collaborative,
+    targetPlaylistID: targetIsID ? target : "",
+    targetPlaylistName: targetIsID ? "" : target,
   });
 
   loading();
+  resetProgress();
 
-  fetch("/port_toptracks", {
+  fetch("/port_toptracks/stream", {
     method: "POST",
     headers: {
       "Content-type": "application/json",
@@ -62,19 +108,15 @@ document.getElementById("port-button").addEventListener("click", () => {
     body: JSON.stringify(data),
   })
     .then((response) => {
-      finishedLoading();
-      if (response.status === 200) {
-        response.json().then((data) => {
-          const count = songNumber - data.tracksNotFound.length;
-          M.toast({
-            html: `${count}/${songNumber} songs were successfully imported.`,
-          });
-        });
-      } else {
-        response.text().then(function (text) {
+      if (response.status !== 200) {
+        finishedLoading();
+        return response.text().then(function (text) {
           M.toast({ html: text });
         });
       }
+      return readEvents(response.body.getReader(), handlePortEvent).then(
+        finishedLoading
+      );
     })
     .catch((error) => {
       finishedLoading();
@@ -83,6 +125,113 @@ document.getElementById("port-button").addEventListener("click", () => {
     });
 });
 
+/**
+ * Gets the Unix time of a time on a date from a date input, in the user's time zone
+ */
+function unixTime(date, time) {
+  return Math.floor(new Date(`${date}T${time}`).getTime() / 1000);
+}
+
+/**
+ * Reads Server-Sent Events from a stream and calls handler with the name and data of each
+ */
+async function readEvents(reader, handler) {
+  const decoder = new TextDecoder();
+  let buffer = "";
+
+  for (;;) {
+    const { done, value } = await reader.read();
+    if (done) {
+      return;
+    }
+    buffer += decoder.decode(value, { stream: true });
+
+    let end;
+    while ((end = buffer.indexOf("\n\n")) !== -1) {
+      const message = buffer.slice(0, end);
+      buffer = buffer.slice(end + 2);
+
+      let event = "message";
+      let data = "";
+      for (const line of message.split("\n")) {
+        if (line.startsWith("event: ")) {
+          event = line.slice(7);
+        } else if (line.startsWith("data: ")) {
+          data += line.slice(6);
+        }
+      }
+      handler(event, JSON.parse(data));
+    }
+  }
+}
+
+function resetProgress() {
+  document.getElementById("port-progress").textContent = "";
+  document.getElementById("port-progress-tracks").innerHTML = "";
+}
+
+function setTrackProgress(data, status) {
+  const id = `port-track-${data.index}`;
+  let item = document.getElementById(id);
+  if (!item) {
+    item = document.createElement("li");
+    item.id = id;
+    item.className = "collection-item";
+    document.getElementById("port-progress-tracks").appendChild(item);
+  }
+  item.textContent = `${data.track.Artist} - ${data.track.Title}: ${status}`;
+}
+
+function handlePortEvent(event, data) {
+  const progress = document.getElementById("port-progress");
+
+  switch (event) {
+    case "searching":
+      progress.textContent = `Searching Spotify for song ${data.index + 1} of ${data.total}`;
+      setTrackProgress(data, "searching");
+      break;
+    case "matched":
+      setTrackProgress(data, "found");
+      break;
+    case "not-found":
+      setTrackProgress(data, "not found");
+      break;
+    case "playlist-created":
+      progress.textContent = "Created the playlist, adding the songs";
+      break;
+    case "playlist-found":
+      progress.textContent = `Replacing the songs in ${data.name || "the playlist"}`;
+      break;
+    case "tracks-added": {
+      const total =
+        data.added +
+        (data.alreadySaved || 0) +
+        (data.tracksNotFound || []).length +
+        (data.failedChunks || []).reduce((n, c) => n + c.tracks.length, 0);
+      progress.textContent = "";
+      M.toast({
+        html: `${data.added}/${total} songs were successfully imported.`,
+      });
+      if (data.alreadySaved) {
+        M.toast({
+          html: `${data.alreadySaved} songs were already in your Liked Songs.`,
+        });
+      }
+      if (data.failedChunks && data.failedChunks.length) {
+        const failed = data.failedChunks.reduce(
+          (count, chunk) => count + chunk.tracks.length,
+          0
+        );
+        M.toast({ html: `${failed} songs could not be added to the playlist.` });
+      }
+      break;
+    }
+    case "error":
+      M.toast({ html: data.message });
+      break;
+  }
+}
+
 function showSpotifyLoginDiv() {
   const spotifyLoginDiv = document.getElementById("spotify-login-div");
   spotifyLoginDiv.style.display = "";